@@ -0,0 +1,72 @@
+package blte
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Keys provides the TACT keys used to decrypt encrypted ('E') blocks.
+// Implementations must be safe for concurrent use.
+type Keys interface {
+	// Key returns the key named name.
+	Key(name uint64) (key []byte, ok bool)
+}
+
+// KeyRing is a Keys implementation mapping key names to keys.
+// A KeyRing must not be modified while it is used to read BLTE data.
+type KeyRing map[uint64][]byte
+
+// Key returns the key named name.
+func (k KeyRing) Key(name uint64) ([]byte, bool) {
+	key, ok := k[name]
+	return key, ok
+}
+
+// ParseKeys parses lines of hex encoded "keyname keyvalue" pairs such as
+// "FA505078126ACB3E BDC51862ABED79B2DE48C8E7E66C6200".
+// The key name and value may also be separated by a semicolon.
+// Empty lines and lines starting with '#' are ignored as well as any field after the key value.
+func ParseKeys(r io.Reader) (KeyRing, error) {
+	keys := KeyRing{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		fields := strings.FieldsFunc(text, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ';'
+		})
+		if len(fields) < 2 {
+			return nil, errors.WithStack(errors.Errorf("line %d: expected key name and key value", line))
+		}
+		name, err := strconv.ParseUint(fields[0], 16, 64)
+		if err != nil {
+			return nil, errors.WithStack(errors.Errorf("line %d: invalid key name %q", line, fields[0]))
+		}
+		key, err := hex.DecodeString(fields[1])
+		if err != nil || len(key) != 16 {
+			return nil, errors.WithStack(errors.Errorf("line %d: invalid key value %q", line, fields[1]))
+		}
+		keys[name] = key
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return keys, nil
+}
+
+// MissingKeyError is returned when reading a block encrypted with an unknown key.
+type MissingKeyError struct {
+	Name uint64
+}
+
+func (e *MissingKeyError) Error() string {
+	return fmt.Sprintf("missing key %016X", e.Name)
+}
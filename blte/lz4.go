@@ -0,0 +1,108 @@
+package blte
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// lz4Decompress decompresses the content of a LZ4 ('4') block.
+// The content starts with a header made of a version (1), the big endian uint64 decompressed size
+// and a block shift. The decompressed data is split in blocks of 1<<shift bytes,
+// each compressed as a LZ4 block. The blocks are stored one after the other.
+func lz4Decompress(src []byte) ([]byte, error) {
+	if len(src) < 10 {
+		return nil, errors.WithStack(errors.New("lz4: invalid header"))
+	}
+	if src[0] != 1 {
+		return nil, errors.WithStack(errors.Errorf("lz4: unsupported version %d", src[0]))
+	}
+	size := binary.BigEndian.Uint64(src[1:9])
+	shift := src[9]
+	if shift > 30 {
+		return nil, errors.WithStack(errors.Errorf("lz4: invalid block shift %d", shift))
+	}
+	src = src[10:]
+	blockSize := uint64(1) << shift
+	// The decompressed size is not trusted to preallocate dst.
+	dst := make([]byte, 0, minUint64(size, uint64(len(src))*4))
+	for uint64(len(dst)) < size {
+		end := uint64(len(dst)) + minUint64(blockSize, size-uint64(len(dst)))
+		var err error
+		if src, dst, err = lz4DecodeBlock(src, dst, int(end)); err != nil {
+			return nil, err
+		}
+	}
+	return dst, nil
+}
+
+// lz4DecodeBlock decodes the LZ4 block starting at src and appends it to dst
+// until dst is end bytes long. The remaining of src is returned.
+// Matches may refer to data previously appended to dst.
+func lz4DecodeBlock(src, dst []byte, end int) ([]byte, []byte, error) {
+	errCorrupted := errors.New("lz4: corrupted block")
+	readLen := func(n int) (int, error) {
+		if n != 15 {
+			return n, nil
+		}
+		for {
+			if len(src) == 0 {
+				return 0, errors.WithStack(errCorrupted)
+			}
+			b := src[0]
+			src = src[1:]
+			n += int(b)
+			if b != 255 {
+				return n, nil
+			}
+		}
+	}
+	for len(dst) < end {
+		if len(src) == 0 {
+			return nil, nil, errors.WithStack(errCorrupted)
+		}
+		token := src[0]
+		src = src[1:]
+
+		literals, err := readLen(int(token >> 4))
+		if err != nil {
+			return nil, nil, err
+		}
+		if literals > len(src) || len(dst)+literals > end {
+			return nil, nil, errors.WithStack(errCorrupted)
+		}
+		dst = append(dst, src[:literals]...)
+		src = src[literals:]
+		if len(dst) == end {
+			// The last sequence of a block only contains literals.
+			break
+		}
+
+		if len(src) < 2 {
+			return nil, nil, errors.WithStack(errCorrupted)
+		}
+		offset := int(binary.LittleEndian.Uint16(src))
+		src = src[2:]
+		length, err := readLen(int(token & 0xf))
+		if err != nil {
+			return nil, nil, err
+		}
+		length += 4
+		if offset == 0 || offset > len(dst) || len(dst)+length > end {
+			return nil, nil, errors.WithStack(errCorrupted)
+		}
+		// Copy byte by byte since the match may overlap the bytes being appended.
+		start := len(dst) - offset
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[start+i])
+		}
+	}
+	return src, dst, nil
+}
+
+func minUint64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
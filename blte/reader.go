@@ -1,14 +1,17 @@
-// Package blte implements reading of BLTE format compressed data.
+// Package blte implements reading and writing of BLTE format compressed data.
+// All block types are supported: uncompressed (N), zlib (Z), LZ4 (4), frame (F) and encrypted (E).
 package blte
 
 import (
 	"bytes"
 	"compress/zlib"
 	"crypto/md5"
+	"crypto/rc4"
 	"encoding/binary"
 	"fmt"
 	"hash"
 	"io"
+	"io/ioutil"
 
 	"github.com/pkg/errors"
 )
@@ -29,9 +32,43 @@ type chunkInfoEntry struct {
 	Checksum [0x10]uint8
 }
 
+// Option configures how BLTE data is decoded.
+type Option func(*options)
+
+type options struct {
+	keys     Keys
+	zeroFill bool
+}
+
+// WithKeys makes the reader decrypt encrypted blocks using keys.
+func WithKeys(keys Keys) Option {
+	return func(o *options) {
+		o.keys = keys
+	}
+}
+
+// WithZeroFill makes the reader return zeros in place of the content of
+// encrypted blocks whose key is missing instead of a *MissingKeyError.
+// Only blocks whose uncompressed size is known from the header can be zero filled.
+func WithZeroFill() Option {
+	return func(o *options) {
+		o.zeroFill = true
+	}
+}
+
 // NewReader creates a new io.Reader.
 // Reads from the returned Reader read and decompress data from r.
-func NewReader(r io.Reader) (io.Reader, error) {
+// Reading a block encrypted with a key not provided by WithKeys returns a *MissingKeyError.
+func NewReader(r io.Reader, opts ...Option) (io.Reader, error) {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return newReader(r, o)
+}
+
+// newReader creates a new io.Reader decoding the BLTE data read from r using o.
+func newReader(r io.Reader, o options) (io.Reader, error) {
 	h := header{}
 	if err := binary.Read(r, binary.BigEndian, &h); err != nil {
 		return nil, errors.WithStack(err)
@@ -40,7 +77,7 @@ func NewReader(r io.Reader) (io.Reader, error) {
 		return nil, errors.WithStack(fmt.Errorf("invalid signature %x", h.Sig))
 	}
 	if h.Size == 0 {
-		return createReader(r, 0, 0, [0x10]byte{})
+		return createReader(r, 0, 0, [0x10]byte{}, 0, o)
 	}
 	info := chunkInfo{}
 	if err := binary.Read(r, binary.BigEndian, &info); err != nil {
@@ -57,12 +94,13 @@ func NewReader(r io.Reader) (io.Reader, error) {
 		}
 		entries = append(entries, entry)
 	}
-	return &chunksReader{r: r, entries: entries}, nil
+	return &chunksReader{r: r, entries: entries, opts: o}, nil
 }
 
 // createReader returns a io.Reader that decompress a data chunk.
 // Provide the zero values of usize, csize and checksum if the blte file has no header.
-func createReader(r io.Reader, usize, csize int, checksum [0x10]byte) (io.Reader, error) {
+// index is the index of the chunk, used to decrypt encrypted chunks.
+func createReader(r io.Reader, usize, csize int, checksum [0x10]byte, index int, o options) (io.Reader, error) {
 	allOrNone := (csize > 0) == (usize > 0) && (usize > 0) == (checksum != [0x10]byte{})
 	if !allOrNone {
 		return nil, errors.WithStack(errors.New("invalid chunk info entry"))
@@ -80,32 +118,139 @@ func createReader(r io.Reader, usize, csize int, checksum [0x10]byte) (io.Reader
 		r = &checksumReader{r: r, digest: digest, checksum: checksum}
 	}
 
+	if typ == 'N' && csize != usize {
+		return nil, errors.WithStack(
+			fmt.Errorf("compressed and uncompressed size should be equal %d != %d", csize, usize))
+	}
+	r, err := decode(typ, r, usize, index, o)
+	if err != nil {
+		return nil, err
+	}
+
+	if usize > 0 {
+		r = &sizeReader{r: r, size: usize}
+	}
+	return r, nil
+}
+
+// decode returns a io.Reader that decodes the content of a block of type typ read from r.
+// usize is the uncompressed size of the block or 0 if unknown.
+func decode(typ uint8, r io.Reader, usize, index int, o options) (io.Reader, error) {
 	switch typ {
 	case 'N':
-		if csize != usize {
-			return nil, errors.WithStack(
-				fmt.Errorf("compressed and uncompressed size should be equal %d != %d", csize, usize))
-		}
+		return r, nil
 	case 'Z':
 		zreader, err := zlib.NewReader(r)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
-		r = &eofCloser{r: zreader}
+		return &eofCloser{r: zreader}, nil
+	case 'F':
+		// A frame contains BLTE data.
+		return newReader(r, o)
+	case '4':
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		decompressed, err := lz4Decompress(b)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(decompressed), nil
+	case 'E':
+		// The whole block is read so that the checksum is verified
+		// and the next block can be read even if the key is missing.
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		decrypted, err := decrypt(b, index, o.keys)
+		var missingKey *MissingKeyError
+		if errors.As(err, &missingKey) && o.zeroFill && usize > 0 {
+			return bytes.NewReader(make([]byte, usize)), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(decrypted) == 0 {
+			return nil, errors.WithStack(errors.New("empty encrypted block"))
+		}
+		return decode(decrypted[0], bytes.NewReader(decrypted[1:]), usize, index, o)
 	default:
 		return nil, errors.WithStack(errors.Errorf("unsuported encoding type %+q", typ))
 	}
+}
 
-	if usize > 0 {
-		r = &sizeReader{r: r, size: usize}
+// decrypt decrypts the content of an encrypted block.
+// The content is made of the key name, the IV, the encryption type ('S' for Salsa20, 'A' for ARC4)
+// and the encrypted data, whose first byte is the encoding type of the decrypted block.
+func decrypt(b []byte, index int, keys Keys) ([]byte, error) {
+	r := bytes.NewReader(b)
+	var nameSize uint8
+	if err := binary.Read(r, binary.LittleEndian, &nameSize); err != nil {
+		return nil, errors.WithStack(err)
 	}
-	return r, nil
+	if nameSize != 8 {
+		return nil, errors.WithStack(errors.Errorf("unsupported key name size %d", nameSize))
+	}
+	var name uint64
+	if err := binary.Read(r, binary.LittleEndian, &name); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var ivSize uint8
+	if err := binary.Read(r, binary.LittleEndian, &ivSize); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if ivSize != 4 && ivSize != 8 {
+		return nil, errors.WithStack(errors.Errorf("unsupported IV size %d", ivSize))
+	}
+	var iv [8]byte
+	if _, err := io.ReadFull(r, iv[:ivSize]); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var encType uint8
+	if err := binary.Read(r, binary.LittleEndian, &encType); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var key []byte
+	var ok bool
+	if keys != nil {
+		key, ok = keys.Key(name)
+	}
+	if !ok {
+		return nil, errors.WithStack(&MissingKeyError{Name: name})
+	}
+	// The IV is unique to each block: its first 4 bytes are XORed with the block index.
+	for i := 0; i < 4; i++ {
+		iv[i] ^= byte(index >> (8 * uint(i)))
+	}
+	encrypted := b[len(b)-r.Len():]
+	decrypted := make([]byte, len(encrypted))
+	switch encType {
+	case 'S':
+		if len(key) != 16 && len(key) != 32 {
+			return nil, errors.WithStack(errors.Errorf("invalid key %016X len %d", name, len(key)))
+		}
+		salsa20XOR(decrypted, encrypted, iv, key)
+	case 'A':
+		// The ARC4 key is the TACT key followed by the IV.
+		cipher, err := rc4.NewCipher(append(append([]byte{}, key...), iv[:ivSize]...))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		cipher.XORKeyStream(decrypted, encrypted)
+	default:
+		return nil, errors.WithStack(errors.Errorf("unsupported encryption type %+q", encType))
+	}
+	return decrypted, nil
 }
 
 // chunksReader reads blte data consisting of multiple chunks.
 type chunksReader struct {
 	r       io.Reader
 	entries []chunkInfoEntry
+	opts    options
 
 	index int
 	next  io.Reader
@@ -120,7 +265,9 @@ func (r *chunksReader) step() error {
 	r.next, err = createReader(r.r,
 		int(r.entries[r.index].USize),
 		int(r.entries[r.index].Csize)-1,
-		r.entries[r.index].Checksum)
+		r.entries[r.index].Checksum,
+		r.index,
+		r.opts)
 	r.index++
 	return err
 }
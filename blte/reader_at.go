@@ -0,0 +1,214 @@
+package blte
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// chunkAt locates a chunk within BLTE data.
+type chunkAt struct {
+	chunkInfoEntry
+	offset  int64 // offset of the encoded chunk
+	uoffset int64 // offset of the decoded chunk
+}
+
+// ReaderAt provides random access to the decoded content of BLTE data.
+// Only the chunks covering the requested ranges are read and decoded.
+// The last decoded chunk is kept in memory.
+// ReadAt is safe for concurrent use, Read and Seek are not.
+type ReaderAt struct {
+	r          io.ReaderAt
+	opts       options
+	headerless bool
+	chunks     []chunkAt
+	size       int64
+
+	mu     sync.Mutex
+	cached int
+	cache  []byte
+
+	off int64
+}
+
+// NewReaderAt creates a new ReaderAt decoding the BLTE data of size bytes read from r.
+// BLTE data without a chunk table is made of a single chunk of unknown size
+// and is decoded entirely by NewReaderAt.
+// The header is read with two calls to r.ReadAt and each chunk with a single call
+// which keeps the number of requests low if r fetches remote data.
+func NewReaderAt(r io.ReaderAt, size int64, opts ...Option) (*ReaderAt, error) {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	ra := &ReaderAt{r: r, opts: o, cached: -1}
+	h := header{}
+	if err := binary.Read(io.NewSectionReader(r, 0, 8), binary.BigEndian, &h); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if h.Sig != 0x424c5445 {
+		return nil, errors.WithStack(fmt.Errorf("invalid signature %x", h.Sig))
+	}
+	if h.Size == 0 {
+		ra.headerless = true
+		ra.chunks = []chunkAt{{
+			chunkInfoEntry: chunkInfoEntry{Csize: uint32(size - 8)},
+			offset:         8,
+		}}
+		b, err := ra.decode(0)
+		if err != nil {
+			return nil, err
+		}
+		ra.chunks[0].USize = uint32(len(b))
+		ra.size = int64(len(b))
+		ra.cached, ra.cache = 0, b
+		return ra, nil
+	}
+	if h.Size < 12 || int64(h.Size) > size {
+		return nil, errors.WithStack(errors.Errorf("invalid header size %d", h.Size))
+	}
+	headerB := make([]byte, h.Size-8)
+	if err := readFullAt(r, headerB, 8); err != nil {
+		return nil, err
+	}
+	hr := bytes.NewReader(headerB)
+	info := chunkInfo{}
+	if err := binary.Read(hr, binary.BigEndian, &info); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if h.Size != 12+uint32(info.Count)*24 {
+		return nil, errors.WithStack(errors.Errorf("expected header size %d", h.Size))
+	}
+	offset := int64(h.Size)
+	for i := uint16(0); i < info.Count; i++ {
+		entry := chunkInfoEntry{}
+		if err := binary.Read(hr, binary.BigEndian, &entry); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		ra.chunks = append(ra.chunks, chunkAt{chunkInfoEntry: entry, offset: offset, uoffset: ra.size})
+		offset += int64(entry.Csize)
+		ra.size += int64(entry.USize)
+	}
+	if offset > size {
+		return nil, errors.WithStack(errors.Errorf("chunks size %d exceed size %d", offset, size))
+	}
+	return ra, nil
+}
+
+// Size returns the size of the decoded content.
+func (ra *ReaderAt) Size() int64 {
+	return ra.size
+}
+
+// decode reads and decodes the chunk i.
+func (ra *ReaderAt) decode(i int) ([]byte, error) {
+	c := ra.chunks[i]
+	encoded := make([]byte, c.Csize)
+	if err := readFullAt(ra.r, encoded, c.offset); err != nil {
+		return nil, err
+	}
+	var r io.Reader
+	var err error
+	if ra.headerless {
+		r, err = createReader(bytes.NewReader(encoded), 0, 0, [0x10]byte{}, i, ra.opts)
+	} else {
+		r, err = createReader(bytes.NewReader(encoded), int(c.USize), int(c.Csize)-1, c.Checksum, i, ra.opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return b, nil
+}
+
+// chunk returns the decoded chunk i.
+func (ra *ReaderAt) chunk(i int) ([]byte, error) {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	if ra.cached == i {
+		return ra.cache, nil
+	}
+	b, err := ra.decode(i)
+	if err != nil {
+		return nil, err
+	}
+	ra.cached, ra.cache = i, b
+	return b, nil
+}
+
+// ReadAt implements io.ReaderAt on the decoded content.
+func (ra *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.WithStack(errors.New("negative offset"))
+	}
+	i := sort.Search(len(ra.chunks), func(i int) bool {
+		return ra.chunks[i].uoffset+int64(ra.chunks[i].USize) > off
+	})
+	n := 0
+	for ; n < len(p) && i < len(ra.chunks); i++ {
+		b, err := ra.chunk(i)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], b[off+int64(n)-ra.chunks[i].uoffset:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Read implements io.Reader on the decoded content.
+func (ra *ReaderAt) Read(p []byte) (int, error) {
+	if ra.off >= ra.size {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > ra.size-ra.off {
+		p = p[:ra.size-ra.off]
+	}
+	n, err := ra.ReadAt(p, ra.off)
+	ra.off += int64(n)
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker on the decoded content.
+func (ra *ReaderAt) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += ra.off
+	case io.SeekEnd:
+		offset += ra.size
+	default:
+		return 0, errors.WithStack(errors.New("invalid whence"))
+	}
+	if offset < 0 {
+		return 0, errors.WithStack(errors.New("negative position"))
+	}
+	ra.off = offset
+	return offset, nil
+}
+
+// readFullAt reads exactly len(p) bytes at off.
+func readFullAt(r io.ReaderAt, p []byte, off int64) error {
+	n, err := r.ReadAt(p, off)
+	if n == len(p) {
+		return nil
+	}
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return errors.WithStack(err)
+}
@@ -0,0 +1,82 @@
+package blte
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// maxReaderAt records the end of the furthest range read.
+type maxReaderAt struct {
+	r   io.ReaderAt
+	max int64
+}
+
+func (m *maxReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := m.r.ReadAt(p, off)
+	if end := off + int64(n); end > m.max {
+		m.max = end
+	}
+	return n, err
+}
+
+func TestReaderAt(t *testing.T) {
+	data := []byte(strings.Repeat("hello, world\n", 1000))
+	for _, espec := range []string{"z", "b:{1K*=z}", "b:{100=n,1K*=n}"} {
+		encoded, _ := encode(t, espec, data)
+		r := &maxReaderAt{r: bytes.NewReader(encoded)}
+		ra, err := NewReaderAt(r, int64(len(encoded)))
+		if err != nil {
+			t.Fatalf("%s: %+v", espec, err)
+		}
+		if ra.Size() != int64(len(data)) {
+			t.Fatalf("%s: unexpected size %d", espec, ra.Size())
+		}
+		for _, rng := range [][2]int{{0, 10}, {1020, 1030}, {5000, 8000}, {0, len(data)}, {len(data) - 3, len(data)}} {
+			p := make([]byte, rng[1]-rng[0])
+			n, err := ra.ReadAt(p, int64(rng[0]))
+			if err != nil {
+				t.Fatalf("%s %v: %+v", espec, rng, err)
+			}
+			if bytes.Compare(data[rng[0]:rng[1]], p[:n]) != 0 {
+				t.Fatalf("%s %v: unexpected content %q", espec, rng, p[:n])
+			}
+		}
+		if n, err := ra.ReadAt(make([]byte, 10), int64(len(data)-5)); n != 5 || err != io.EOF {
+			t.Fatalf("%s: expected io.EOF got %d %v", espec, n, err)
+		}
+
+		if _, err := ra.Seek(-13, io.SeekEnd); err != nil {
+			t.Fatal(err)
+		}
+		tail, err := ioutil.ReadAll(ra)
+		if err != nil {
+			t.Fatalf("%s: %+v", espec, err)
+		}
+		if string(tail) != "hello, world\n" {
+			t.Fatalf("%s: unexpected tail %q", espec, tail)
+		}
+	}
+}
+
+func TestReaderAtDecodesOnlyNeededChunks(t *testing.T) {
+	data := []byte(strings.Repeat("hello, world\n", 1000))
+	encoded, _ := encode(t, "b:{1K*=n}", data)
+	r := &maxReaderAt{r: bytes.NewReader(encoded)}
+	ra, err := NewReaderAt(r, int64(len(encoded)))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	headerSize := int64(12 + 24*13)
+	if r.max != headerSize {
+		t.Fatalf("expected only the header to be read, read up to %d", r.max)
+	}
+	if _, err := ra.ReadAt(make([]byte, 10), 0); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if r.max != headerSize+1+1024 {
+		t.Fatalf("expected only the first chunk to be read, read up to %d", r.max)
+	}
+}
@@ -3,8 +3,12 @@ package blte
 import (
 	"bytes"
 	"crypto/md5"
+	"crypto/rc4"
 	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"io/ioutil"
+	"strings"
 	"testing"
 )
 
@@ -79,3 +83,205 @@ func concat(slices ...[]byte) []byte {
 	}
 	return tmp
 }
+
+func TestSalsa20(t *testing.T) {
+	// eSTREAM Salsa20/20 128 bits key test vector set 1, vector 0.
+	key := make([]byte, 16)
+	key[0] = 0x80
+	expected, _ := hex.DecodeString("4DFA5E481DA23EA09A31022050859936DA52FCEE218005164F267CB65F5CFD7F" +
+		"2B4F97E0FF16924A52DF269515110A07F9E460BC65EF95DA58F740B7D1DBB0AA")
+	actual := make([]byte, 64)
+	salsa20XOR(actual, make([]byte, 64), [8]byte{}, key)
+	if bytes.Compare(expected, actual) != 0 {
+		t.Fatalf("exected:%X\nactual:%X", expected, actual)
+	}
+}
+
+var testKey = []byte{0: 0x42, 15: 0x24}
+
+const testKeyName = uint64(0xFA505078126ACB3E)
+
+// encrypt returns the content of an 'E' block containing the block b encrypted with testKey.
+func encrypt(encType byte, b []byte, index int) []byte {
+	iv := []byte{1, 2, 3, 4}
+	shuffled := [8]byte{}
+	copy(shuffled[:], iv)
+	for i := 0; i < 4; i++ {
+		shuffled[i] ^= byte(index >> (8 * uint(i)))
+	}
+	encrypted := make([]byte, len(b))
+	if encType == 'S' {
+		salsa20XOR(encrypted, b, shuffled, testKey)
+	} else {
+		cipher, _ := rc4.NewCipher(append(append([]byte{}, testKey...), shuffled[:4]...))
+		cipher.XORKeyStream(encrypted, b)
+	}
+	name := make([]byte, 8)
+	binary.LittleEndian.PutUint64(name, testKeyName)
+	return concat([]byte{'E', 8}, name, []byte{4}, iv, []byte{encType}, encrypted)
+}
+
+func TestEncryptedChunk(t *testing.T) {
+	for _, encType := range []byte{'S', 'A'} {
+		blte := concat([]byte{66, 76, 84, 69, 0, 0, 0, 0}, encrypt(encType, []byte("Nhello, world"), 0))
+		r, err := NewReader(bytes.NewReader(blte), WithKeys(KeyRing{testKeyName: testKey}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%c: %+v", encType, err)
+		}
+		if string(actual) != "hello, world" {
+			t.Fatalf("%c: unexpected %q", encType, actual)
+		}
+	}
+}
+
+// encryptedChunks returns a blte file made of an encrypted chunk and an uncompressed chunk.
+func encryptedChunks() []byte {
+	encrypted := encrypt('S', []byte("Nhello"), 0)
+	uncompressed := []byte("N, world")
+	hashE := md5.Sum(encrypted)
+	hashN := md5.Sum(uncompressed)
+	return concat(
+		[]byte{66, 76, 84, 69, 0, 0, 0, 12 + 24*2, 0, 0, 0, 2},
+		[]byte{0, 0, 0, byte(len(encrypted)), 0, 0, 0, 5}, hashE[:],
+		[]byte{0, 0, 0, byte(len(uncompressed)), 0, 0, 0, 7}, hashN[:],
+		encrypted,
+		uncompressed,
+	)
+}
+
+func TestEncryptedChunksMissingKey(t *testing.T) {
+	r, err := NewReader(bytes.NewReader(encryptedChunks()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(r)
+	var missingKey *MissingKeyError
+	if !errors.As(err, &missingKey) {
+		t.Fatalf("expected missing key error got %+v", err)
+	}
+	if missingKey.Name != testKeyName {
+		t.Fatalf("unexpected key name %X", missingKey.Name)
+	}
+
+	r, err = NewReader(bytes.NewReader(encryptedChunks()), WithZeroFill())
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if string(actual) != "\x00\x00\x00\x00\x00, world" {
+		t.Fatalf("unexpected %q", actual)
+	}
+
+	r, err = NewReader(bytes.NewReader(encryptedChunks()), WithKeys(KeyRing{testKeyName: testKey}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual, err = ioutil.ReadAll(r); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if string(actual) != "hello, world" {
+		t.Fatalf("unexpected %q", actual)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys(strings.NewReader(`# comment
+
+FA505078126ACB3E BDC51862ABED79B2DE48C8E7E66C6200
+ff813f7d062ac0bc;aa0b5c77f088ccc2d39049bd267f066d;WoW
+`))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	key, ok := keys.Key(0xFA505078126ACB3E)
+	if !ok || hex.EncodeToString(key) != "bdc51862abed79b2de48c8e7e66c6200" {
+		t.Fatalf("unexpected key %x", key)
+	}
+	if _, ok := keys.Key(0xFF813F7D062AC0BC); !ok {
+		t.Fatal("key not found")
+	}
+	if _, err := ParseKeys(strings.NewReader("FA505078126ACB3E BDC5")); err == nil {
+		t.Fatal("expected invalid key error")
+	}
+}
+
+func TestLZ4Chunk(t *testing.T) {
+	tests := []struct {
+		name     string
+		block    []byte
+		expected string
+	}{
+		{
+			name: "single block",
+			block: concat(
+				[]byte{'4', 1, 0, 0, 0, 0, 0, 0, 0, 24, 16},
+				[]byte{0x6d}, []byte("hello "), []byte{6, 0},
+				[]byte{0x10}, []byte("!"),
+			),
+			expected: "hello hello hello hello!",
+		},
+		{
+			name: "length extension",
+			block: concat(
+				[]byte{'4', 1, 0, 0, 0, 0, 0, 0, 0, 40, 16},
+				[]byte{0x1f}, []byte("a"), []byte{1, 0, 15},
+				[]byte{0x50}, []byte("aaaaa"),
+			),
+			expected: strings.Repeat("a", 40),
+		},
+		{
+			name: "two blocks",
+			block: concat(
+				[]byte{'4', 1, 0, 0, 0, 0, 0, 0, 0, 24, 4},
+				[]byte{0x66}, []byte("hello "), []byte{6, 0},
+				[]byte{0x80}, []byte("o hello!"),
+			),
+			expected: "hello hello hello hello!",
+		},
+	}
+	for _, test := range tests {
+		r, err := NewReader(bytes.NewReader(concat([]byte{66, 76, 84, 69, 0, 0, 0, 0}, test.block)))
+		if err != nil {
+			t.Fatalf("%s: %+v", test.name, err)
+		}
+		actual, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: %+v", test.name, err)
+		}
+		if string(actual) != test.expected {
+			t.Fatalf("%s: exected:%q\nactual:%q", test.name, test.expected, actual)
+		}
+	}
+
+	corrupted := []byte{66, 76, 84, 69, 0, 0, 0, 0, '4', 1, 0, 0, 0, 0, 0, 0, 0, 24, 16, 0x6d, 'h', 'e', 'l', 'l', 'o', ' ', 7, 0}
+	if _, err := NewReader(bytes.NewReader(corrupted)); err == nil {
+		t.Fatal("expected corrupted block error")
+	}
+}
+
+func TestFrameChunk(t *testing.T) {
+	frame := concat([]byte{'F'}, twoChunks())
+	hash := md5.Sum(frame)
+	r, err := NewReader(bytes.NewReader(concat(
+		[]byte{66, 76, 84, 69, 0, 0, 0, 12 + 24, 0, 0, 0, 1},
+		[]byte{0, 0, 0, byte(len(frame)), 0, 0, 0, 25}, hash[:],
+		frame,
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if string(actual) != "hello, world\nhello, world" {
+		t.Fatalf("unexpected %q", actual)
+	}
+}
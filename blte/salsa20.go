@@ -0,0 +1,93 @@
+package blte
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// salsa20XOR sets dst to the result of XORing src with the Salsa20/20 key stream
+// generated from key (16 or 32 bytes) and nonce.
+// TACT keys are 16 bytes long which is not supported by golang.org/x/crypto/salsa20.
+func salsa20XOR(dst, src []byte, nonce [8]byte, key []byte) {
+	var constants [16]byte
+	var k0, k1 []byte
+	if len(key) == 16 {
+		copy(constants[:], "expand 16-byte k")
+		k0, k1 = key, key
+	} else {
+		copy(constants[:], "expand 32-byte k")
+		k0, k1 = key[:16], key[16:32]
+	}
+	var input [16]uint32
+	input[0] = binary.LittleEndian.Uint32(constants[0:])
+	for i := 0; i < 4; i++ {
+		input[1+i] = binary.LittleEndian.Uint32(k0[i*4:])
+	}
+	input[5] = binary.LittleEndian.Uint32(constants[4:])
+	input[6] = binary.LittleEndian.Uint32(nonce[0:])
+	input[7] = binary.LittleEndian.Uint32(nonce[4:])
+	input[10] = binary.LittleEndian.Uint32(constants[8:])
+	for i := 0; i < 4; i++ {
+		input[11+i] = binary.LittleEndian.Uint32(k1[i*4:])
+	}
+	input[15] = binary.LittleEndian.Uint32(constants[12:])
+
+	var block [64]byte
+	for counter := uint64(0); len(src) > 0; counter++ {
+		input[8] = uint32(counter)
+		input[9] = uint32(counter >> 32)
+		salsa20Core(&block, &input)
+		n := copy(dst, src)
+		if n > len(block) {
+			n = len(block)
+		}
+		for i := 0; i < n; i++ {
+			dst[i] = src[i] ^ block[i]
+		}
+		dst, src = dst[n:], src[n:]
+	}
+}
+
+// salsa20Core writes the Salsa20/20 block of input to out.
+func salsa20Core(out *[64]byte, input *[16]uint32) {
+	x := *input
+	for i := 0; i < 20; i += 2 {
+		// column round
+		x[4] ^= bits.RotateLeft32(x[0]+x[12], 7)
+		x[8] ^= bits.RotateLeft32(x[4]+x[0], 9)
+		x[12] ^= bits.RotateLeft32(x[8]+x[4], 13)
+		x[0] ^= bits.RotateLeft32(x[12]+x[8], 18)
+		x[9] ^= bits.RotateLeft32(x[5]+x[1], 7)
+		x[13] ^= bits.RotateLeft32(x[9]+x[5], 9)
+		x[1] ^= bits.RotateLeft32(x[13]+x[9], 13)
+		x[5] ^= bits.RotateLeft32(x[1]+x[13], 18)
+		x[14] ^= bits.RotateLeft32(x[10]+x[6], 7)
+		x[2] ^= bits.RotateLeft32(x[14]+x[10], 9)
+		x[6] ^= bits.RotateLeft32(x[2]+x[14], 13)
+		x[10] ^= bits.RotateLeft32(x[6]+x[2], 18)
+		x[3] ^= bits.RotateLeft32(x[15]+x[11], 7)
+		x[7] ^= bits.RotateLeft32(x[3]+x[15], 9)
+		x[11] ^= bits.RotateLeft32(x[7]+x[3], 13)
+		x[15] ^= bits.RotateLeft32(x[11]+x[7], 18)
+		// row round
+		x[1] ^= bits.RotateLeft32(x[0]+x[3], 7)
+		x[2] ^= bits.RotateLeft32(x[1]+x[0], 9)
+		x[3] ^= bits.RotateLeft32(x[2]+x[1], 13)
+		x[0] ^= bits.RotateLeft32(x[3]+x[2], 18)
+		x[6] ^= bits.RotateLeft32(x[5]+x[4], 7)
+		x[7] ^= bits.RotateLeft32(x[6]+x[5], 9)
+		x[4] ^= bits.RotateLeft32(x[7]+x[6], 13)
+		x[5] ^= bits.RotateLeft32(x[4]+x[7], 18)
+		x[11] ^= bits.RotateLeft32(x[10]+x[9], 7)
+		x[8] ^= bits.RotateLeft32(x[11]+x[10], 9)
+		x[9] ^= bits.RotateLeft32(x[8]+x[11], 13)
+		x[10] ^= bits.RotateLeft32(x[9]+x[8], 18)
+		x[12] ^= bits.RotateLeft32(x[15]+x[14], 7)
+		x[13] ^= bits.RotateLeft32(x[12]+x[15], 9)
+		x[14] ^= bits.RotateLeft32(x[13]+x[12], 13)
+		x[15] ^= bits.RotateLeft32(x[14]+x[13], 18)
+	}
+	for i := range x {
+		binary.LittleEndian.PutUint32(out[i*4:], x[i]+input[i])
+	}
+}
@@ -0,0 +1,308 @@
+package blte
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Writer encodes the data written to it as BLTE according to an encoding specification (ESpec).
+// The BLTE header contains the size of every chunk: data is buffered
+// and the encoded data is only written to the underlying io.Writer by Close.
+type Writer struct {
+	w     io.Writer
+	spec  espec
+	buf   bytes.Buffer
+	ekey  []byte
+	close bool
+}
+
+// NewWriter creates a new Writer encoding data according to especString and writing it to w.
+// The supported ESpecs are "n", "z", "z:<level>", "z:{<level>,...}" and
+// "b:{<chunks>}" where chunks are comma separated "<size>[*<count>]=<n|z>" or "<size>*=<n|z>"
+// (chunks of size are used until the end) or "*=<n|z>" (one chunk until the end).
+// Sizes may have a K or M suffix. The default zlib compression level is 9.
+// Encrypted ESpecs ("e:...") are not supported.
+func NewWriter(w io.Writer, especString string) (*Writer, error) {
+	spec, err := parseESpec(especString)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, spec: spec}, nil
+}
+
+// Write buffers p. The data is encoded when Close is called.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.close {
+		return 0, errors.WithStack(errors.New("write after close"))
+	}
+	return w.buf.Write(p)
+}
+
+// Close encodes the buffered data and writes it to the underlying io.Writer.
+// It does not close the underlying io.Writer.
+func (w *Writer) Close() error {
+	if w.close {
+		return nil
+	}
+	w.close = true
+	chunks, err := w.spec.split(w.buf.Bytes())
+	if err != nil {
+		return err
+	}
+	encoded := make([][]byte, 0, len(chunks))
+	for _, c := range chunks {
+		b, err := c.encode()
+		if err != nil {
+			return err
+		}
+		encoded = append(encoded, b)
+	}
+
+	header := bytes.NewBuffer(nil)
+	header.Write([]byte{'B', 'L', 'T', 'E'})
+	if !w.spec.chunked {
+		header.Write([]byte{0, 0, 0, 0})
+	} else {
+		binary.Write(header, binary.BigEndian, uint32(12+24*len(chunks))) // bytes.Buffer never returns an error on Write.
+		header.Write([]byte{0x0f, byte(len(chunks) >> 16), byte(len(chunks) >> 8), byte(len(chunks))})
+		for i, b := range encoded {
+			binary.Write(header, binary.BigEndian, uint32(len(b)))
+			binary.Write(header, binary.BigEndian, uint32(len(chunks[i].data)))
+			checksum := md5.Sum(b)
+			header.Write(checksum[:])
+		}
+	}
+
+	// The encoded key is the MD5 of the header, or of the whole data if there is no header.
+	digest := md5.New()
+	digest.Write(header.Bytes())
+	if !w.spec.chunked {
+		for _, b := range encoded {
+			digest.Write(b)
+		}
+	}
+	w.ekey = digest.Sum(nil)
+
+	if _, err := w.w.Write(header.Bytes()); err != nil {
+		return errors.WithStack(err)
+	}
+	for _, b := range encoded {
+		if _, err := w.w.Write(b); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// EncodedKey returns the encoded key (EKey) of the data written by Close,
+// or nil if Close was not called.
+func (w *Writer) EncodedKey() []byte {
+	return w.ekey
+}
+
+// espec is a parsed encoding specification.
+type espec struct {
+	chunked bool
+	blocks  []especBlock
+}
+
+// especBlock describes count chunks of size bytes.
+// A size of 0 means until the end of the data. A count of 0 means as many chunks as needed.
+type especBlock struct {
+	size  int
+	count int
+	enc   encoding
+}
+
+// encoding describes how a chunk is encoded.
+type encoding struct {
+	typ   byte
+	level int
+}
+
+func parseESpec(s string) (espec, error) {
+	if strings.HasPrefix(s, "b:") {
+		inner := strings.TrimPrefix(s, "b:")
+		if !strings.HasPrefix(inner, "{") || !strings.HasSuffix(inner, "}") {
+			return espec{}, errors.WithStack(errors.Errorf("invalid espec %q", s))
+		}
+		inner = inner[1 : len(inner)-1]
+		spec := espec{chunked: true}
+		for _, part := range splitTopLevel(inner) {
+			block, err := parseESpecBlock(part)
+			if err != nil {
+				return espec{}, err
+			}
+			spec.blocks = append(spec.blocks, block)
+		}
+		return spec, nil
+	}
+	enc, err := parseEncoding(s)
+	if err != nil {
+		return espec{}, err
+	}
+	return espec{blocks: []especBlock{{enc: enc}}}, nil
+}
+
+// splitTopLevel splits s on the commas that are not within braces.
+func splitTopLevel(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func parseESpecBlock(s string) (especBlock, error) {
+	i := strings.Index(s, "=")
+	if i < 0 {
+		return especBlock{}, errors.WithStack(errors.Errorf("invalid espec block %q", s))
+	}
+	enc, err := parseEncoding(s[i+1:])
+	if err != nil {
+		return especBlock{}, err
+	}
+	block := especBlock{enc: enc}
+	sizeSpec := s[:i]
+	if sizeSpec == "*" {
+		return block, nil
+	}
+	block.count = 1
+	if j := strings.Index(sizeSpec, "*"); j >= 0 {
+		block.count = 0
+		if count := sizeSpec[j+1:]; count != "" {
+			if block.count, err = strconv.Atoi(count); err != nil || block.count <= 0 {
+				return especBlock{}, errors.WithStack(errors.Errorf("invalid espec block count %q", s))
+			}
+		}
+		sizeSpec = sizeSpec[:j]
+	}
+	multiplier := 1
+	switch {
+	case strings.HasSuffix(sizeSpec, "K"):
+		multiplier = 1024
+	case strings.HasSuffix(sizeSpec, "M"):
+		multiplier = 1024 * 1024
+	}
+	if multiplier > 1 {
+		sizeSpec = sizeSpec[:len(sizeSpec)-1]
+	}
+	size, err := strconv.Atoi(sizeSpec)
+	if err != nil || size <= 0 {
+		return especBlock{}, errors.WithStack(errors.Errorf("invalid espec block size %q", s))
+	}
+	block.size = size * multiplier
+	return block, nil
+}
+
+func parseEncoding(s string) (encoding, error) {
+	switch {
+	case s == "n":
+		return encoding{typ: 'N'}, nil
+	case s == "z":
+		return encoding{typ: 'Z', level: 9}, nil
+	case strings.HasPrefix(s, "z:"):
+		level := strings.TrimPrefix(s, "z:")
+		if strings.HasPrefix(level, "{") && strings.HasSuffix(level, "}") {
+			// i.e. z:{9,mpq}, only the level is used.
+			level = strings.Split(level[1:len(level)-1], ",")[0]
+		}
+		l, err := strconv.Atoi(level)
+		if err != nil || l < zlib.HuffmanOnly || l > zlib.BestCompression {
+			return encoding{}, errors.WithStack(errors.Errorf("invalid zlib espec %q", s))
+		}
+		return encoding{typ: 'Z', level: l}, nil
+	default:
+		return encoding{}, errors.WithStack(errors.Errorf("unsupported espec %q", s))
+	}
+}
+
+// chunk is data to be encoded as a single chunk.
+type chunk struct {
+	data []byte
+	enc  encoding
+}
+
+// split splits b into chunks according to the blocks of s.
+func (s espec) split(b []byte) ([]chunk, error) {
+	var chunks []chunk
+	for i, block := range s.blocks {
+		last := i == len(s.blocks)-1
+		switch {
+		case block.size == 0:
+			if !last {
+				return nil, errors.WithStack(errors.New("espec: only the last block can extend until the end"))
+			}
+			if len(b) > 0 || !s.chunked {
+				chunks = append(chunks, chunk{b, block.enc})
+			}
+			b = nil
+		case block.count == 0:
+			if !last {
+				return nil, errors.WithStack(errors.New("espec: only the last block can repeat until the end"))
+			}
+			for len(b) > 0 {
+				n := block.size
+				if n > len(b) {
+					n = len(b)
+				}
+				chunks = append(chunks, chunk{b[:n], block.enc})
+				b = b[n:]
+			}
+		default:
+			for j := 0; j < block.count && len(b) > 0; j++ {
+				n := block.size
+				if n > len(b) {
+					n = len(b)
+				}
+				chunks = append(chunks, chunk{b[:n], block.enc})
+				b = b[n:]
+			}
+		}
+	}
+	if len(b) > 0 {
+		return nil, errors.WithStack(errors.Errorf("espec: %d bytes are not covered", len(b)))
+	}
+	return chunks, nil
+}
+
+// encode returns the encoded chunk including its encoding type.
+func (c chunk) encode() ([]byte, error) {
+	switch c.enc.typ {
+	case 'N':
+		return append([]byte{'N'}, c.data...), nil
+	case 'Z':
+		buf := bytes.NewBuffer([]byte{'Z'})
+		zw, err := zlib.NewWriterLevel(buf, c.enc.level)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if _, err := zw.Write(c.data); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if err := zw.Close(); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, errors.WithStack(errors.Errorf("unsupported encoding type %+q", c.enc.typ))
+	}
+}
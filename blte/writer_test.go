@@ -0,0 +1,101 @@
+package blte
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func encode(t *testing.T, espec string, data []byte) ([]byte, []byte) {
+	t.Helper()
+	buf := bytes.NewBuffer(nil)
+	w, err := NewWriter(buf, espec)
+	if err != nil {
+		t.Fatalf("%s: %+v", espec, err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("%s: %+v", espec, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("%s: %+v", espec, err)
+	}
+	return buf.Bytes(), w.EncodedKey()
+}
+
+func TestWriter(t *testing.T) {
+	data := []byte(strings.Repeat("hello, world\n", 1000))
+	tests := []struct {
+		espec  string
+		chunks int
+	}{
+		{"n", 0},
+		{"z", 0},
+		{"z:6", 0},
+		{"z:{9,mpq}", 0},
+		{"b:{*=z}", 1},
+		{"b:{1K*=n}", 13},
+		{"b:{1K*4=n,2K=z,*=z}", 6},
+		{"b:{16=n,4K*=z}", 5},
+	}
+	for _, test := range tests {
+		encoded, ekey := encode(t, test.espec, data)
+		headerSize := binary.BigEndian.Uint32(encoded[4:8])
+		if test.chunks == 0 {
+			if headerSize != 0 {
+				t.Fatalf("%s: unexpected header size %d", test.espec, headerSize)
+			}
+			if checksum := md5.Sum(encoded); bytes.Compare(checksum[:], ekey) != 0 {
+				t.Fatalf("%s: unexpected ekey %x", test.espec, ekey)
+			}
+		} else {
+			if headerSize != uint32(12+24*test.chunks) {
+				t.Fatalf("%s: unexpected header size %d", test.espec, headerSize)
+			}
+			if checksum := md5.Sum(encoded[:headerSize]); bytes.Compare(checksum[:], ekey) != 0 {
+				t.Fatalf("%s: unexpected ekey %x", test.espec, ekey)
+			}
+		}
+		r, err := NewReader(bytes.NewReader(encoded))
+		if err != nil {
+			t.Fatalf("%s: %+v", test.espec, err)
+		}
+		actual, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: %+v", test.espec, err)
+		}
+		if bytes.Compare(data, actual) != 0 {
+			t.Fatalf("%s: unexpected content", test.espec)
+		}
+	}
+}
+
+func TestWriterUncompressed(t *testing.T) {
+	encoded, _ := encode(t, "n", []byte("hello"))
+	expected := []byte{66, 76, 84, 69, 0, 0, 0, 0, 'N', 'h', 'e', 'l', 'l', 'o'}
+	if bytes.Compare(expected, encoded) != 0 {
+		t.Fatalf("exected:%v\nactual:%v", expected, encoded)
+	}
+}
+
+func TestWriterInvalidESpec(t *testing.T) {
+	for _, espec := range []string{"", "x", "z:10", "b:{*=n,1K=n}", "b:{1K*=n,*=z}", "b:{0=n}", "b:{1K=q}", "e:{FA505078126ACB3E,00000000,z}"} {
+		buf := bytes.NewBuffer(nil)
+		w, err := NewWriter(buf, espec)
+		if err != nil {
+			continue
+		}
+		w.Write([]byte("hello"))
+		if err := w.Close(); err == nil {
+			t.Fatalf("%s: expected error", espec)
+		}
+	}
+	buf := bytes.NewBuffer(nil)
+	w, _ := NewWriter(buf, "b:{1=n}")
+	w.Write([]byte("hello"))
+	if err := w.Close(); err == nil {
+		t.Fatal("expected uncovered bytes error")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/jybp/casc"
+	"github.com/jybp/casc/common"
+	"github.com/pkg/errors"
+)
+
+// runDiff prints the files added (A), removed (D) and modified (M) between two builds.
+// A build is either an install directory, an app code (i.e. w3) for the build currently
+// on Blizzard's CDN or an app code followed by the build config and CDN config hashes
+// (i.e. w3@<build config hash>,<cdn config hash>).
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	var region, cdn, cacheDir string
+	var asJSON, verbose bool
+	fs.StringVar(&region, "region", casc.RegionUS, "app region code")
+	fs.StringVar(&cdn, "cdn", casc.RegionUS, "cdn region")
+	fs.StringVar(&cacheDir, "cache", "", "cache directory for files downloaded from the cdn")
+	fs.BoolVar(&asJSON, "json", false, "print the diff as JSON")
+	fs.BoolVar(&verbose, "v", false, "verbose")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: casc diff [-json] [-region <region>] [-cdn <cdn>] [-cache <cache-dir>] [-v] <old> <new>\n")
+		fmt.Fprintf(fs.Output(), "<old> and <new> are install directories, app codes or app codes with a pinned build (<app>@<build config hash>,<cdn config hash>)\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return nil
+	}
+
+	client := http.DefaultClient
+	if verbose {
+		common.Wlog = os.Stdout
+		client.Transport = logTransport{}
+	}
+
+	open := func(source string) (*casc.Explorer, error) {
+		if fi, err := os.Stat(source); err == nil && fi.IsDir() {
+			return casc.Local(source)
+		}
+		var opts []casc.Option
+		if len(cacheDir) > 0 {
+			opts = append(opts, casc.WithCacheDir(cacheDir))
+		}
+		app := source
+		if i := strings.Index(source, "@"); i >= 0 {
+			app = source[:i]
+			hashes := strings.Split(source[i+1:], ",")
+			if len(hashes) != 2 {
+				return nil, errors.WithStack(errors.Errorf("invalid build %q", source))
+			}
+			opts = append(opts, casc.WithBuild(hashes[0], hashes[1]))
+		}
+		return casc.Online(app, region, cdn, client, opts...)
+	}
+	oldBuild, err := open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	newBuild, err := open(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	diff, err := casc.Diff(oldBuild, newBuild)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return errors.WithStack(enc.Encode(diff))
+	}
+	for _, filename := range diff.Added {
+		fmt.Printf("A %s\n", filename)
+	}
+	for _, filename := range diff.Removed {
+		fmt.Printf("D %s\n", filename)
+	}
+	for _, filename := range diff.Modified {
+		fmt.Printf("M %s\n", filename)
+	}
+	return nil
+}
@@ -1,7 +1,8 @@
 /*
 Explore CASC files from the command-line.
 Usage:
-	casc (-dir <install-dir> | -app <app> [-region <region>] [-cdn <cdn>]) [-o <output-dir>] [-v]
+	casc (-dir <install-dir> [-app <app>] [-product <product>] [-branch <branch>] [-active] | -app <app> [-region <region>] [-cdn <cdn>] [-cache <cache-dir>] [-build <hash> -cdnconfig <hash>] | -app <app> -mirror <mirror-dir> -build <hash> -cdnconfig <hash>) [-keys <keys-file>] [-zerofill] [-locale <locale>] [-platform <platform>] [-listfile <listfile>] [-o <output-dir>] [-v]
+	casc diff [-json] [-region <region>] [-cdn <cdn>] [-cache <cache-dir>] [-v] <old> <new>
 */
 package main
 
@@ -10,14 +11,15 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 
 	"github.com/jybp/casc"
+	"github.com/jybp/casc/blte"
 	"github.com/jybp/casc/common"
+	"github.com/jybp/casc/root/wow"
 	"github.com/pkg/errors"
 )
 
@@ -40,17 +42,34 @@ func main() {
 }
 
 func run() error {
-	var installDir, app, region, cdn, outputDir string
-	var verbose bool
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		return runDiff(os.Args[2:])
+	}
+
+	var installDir, app, region, cdn, outputDir, cacheDir, mirrorDir, buildConfig, cdnConfig string
+	var product, branch, keysFile, locale, platform, listfile string
+	var verbose, active, zeroFill bool
 	flag.StringVar(&installDir, "dir", "", "game install directory")
-	flag.StringVar(&app, "app", "", "app code")
+	flag.StringVar(&app, "app", "", "app code (detected from the install directory if -dir is used)")
 	flag.StringVar(&region, "region", casc.RegionUS, "app region code")
 	flag.StringVar(&cdn, "cdn", casc.RegionUS, "cdn region")
 	flag.StringVar(&outputDir, "o", "", "output directory for extracted files")
+	flag.StringVar(&cacheDir, "cache", "", "cache directory for files downloaded from the cdn")
+	flag.StringVar(&mirrorDir, "mirror", "", "cdn mirror directory (requires -app, -build and -cdnconfig)")
+	flag.StringVar(&buildConfig, "build", "", "build config hash")
+	flag.StringVar(&cdnConfig, "cdnconfig", "", "cdn config hash")
+	flag.StringVar(&product, "product", "", "product code of the .build.info entry to use")
+	flag.StringVar(&branch, "branch", "", "branch of the .build.info entry to use")
+	flag.BoolVar(&active, "active", false, "use an active .build.info entry")
+	flag.StringVar(&keysFile, "keys", "", "file of \"keyname keyvalue\" lines used to decrypt encrypted files")
+	flag.BoolVar(&zeroFill, "zerofill", false, "write zeros in place of content encrypted with a missing key instead of skipping the file")
+	flag.StringVar(&locale, "locale", "", "locale of the extracted files (i.e. enUS)")
+	flag.StringVar(&platform, "platform", "", "platform of the extracted files (i.e. Windows)")
+	flag.StringVar(&listfile, "listfile", "", "file of \"FileDataID;filename\" lines naming World of Warcraft files")
 	flag.BoolVar(&verbose, "v", false, "verbose")
 	flag.Parse()
 
-	if (len(app) == 0) == (len(installDir) == 0) {
+	if len(app) == 0 && len(installDir) == 0 {
 		flag.Usage()
 		return nil
 	}
@@ -61,12 +80,71 @@ func run() error {
 		client.Transport = logTransport{}
 	}
 
+	var opts []casc.Option
+	if len(keysFile) > 0 {
+		f, err := os.Open(keysFile)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		keys, err := blte.ParseKeys(f)
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = errors.WithStack(cerr)
+		}
+		if err != nil {
+			return err
+		}
+		opts = append(opts, casc.WithKeys(keys))
+	}
+	if zeroFill {
+		opts = append(opts, casc.WithZeroFill())
+	}
+	if len(listfile) > 0 {
+		f, err := os.Open(listfile)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		names, err := wow.ParseListfile(f)
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = errors.WithStack(cerr)
+		}
+		if err != nil {
+			return err
+		}
+		opts = append(opts, casc.WithListfile(names))
+	}
+	if len(locale) > 0 {
+		opts = append(opts, casc.WithLocale(locale))
+	}
+	if len(platform) > 0 {
+		opts = append(opts, casc.WithPlatform(platform))
+	}
+
 	var explorer *casc.Explorer
 	var err error
 	if len(installDir) > 0 {
-		explorer, err = casc.Local(installDir)
+		if len(product) > 0 {
+			opts = append(opts, casc.WithProduct(product))
+		}
+		if len(branch) > 0 {
+			opts = append(opts, casc.WithBranch(branch))
+		}
+		if active {
+			opts = append(opts, casc.WithActive())
+		}
+		if len(app) > 0 {
+			opts = append(opts, casc.WithApp(app))
+		}
+		explorer, err = casc.Local(installDir, opts...)
+	} else if len(mirrorDir) > 0 {
+		explorer, err = casc.Mirror(mirrorDir, app, buildConfig, cdnConfig, opts...)
 	} else {
-		explorer, err = casc.Online(app, region, cdn, client)
+		if len(cacheDir) > 0 {
+			opts = append(opts, casc.WithCacheDir(cacheDir))
+		}
+		if len(buildConfig) > 0 || len(cdnConfig) > 0 {
+			opts = append(opts, casc.WithBuild(buildConfig, cdnConfig))
+		}
+		explorer, err = casc.Online(app, region, cdn, client, opts...)
 	}
 	if err != nil {
 		return err
@@ -97,17 +175,46 @@ func run() error {
 		}
 		filename := filepath.Base(string(line))
 		fullpath := filepath.Join(outputDir, filename)
-		b, err := explorer.Extract(string(line))
-		if err == casc.ErrNotFound {
+		rc, err := explorer.Open(string(line))
+		if errors.Is(err, casc.ErrNotFound) {
 			continue
 		}
 		if err != nil {
 			return errors.WithStack(err)
 		}
-		if err := ioutil.WriteFile(fullpath, b, 0666); err != nil {
-			return errors.WithStack(err)
+		var missingKey *blte.MissingKeyError
+		if err := writeFile(fullpath, rc); errors.As(err, &missingKey) {
+			log.Printf("%s: skipped, %v\n", fullpath, missingKey)
+			if err := os.Remove(fullpath); err != nil {
+				return errors.WithStack(err)
+			}
+			continue
+		} else if err != nil {
+			return err
 		}
 		log.Printf("%s\n", fullpath)
 	}
 	return nil
 }
+
+// writeFile streams rc into the file named filename and closes rc.
+func writeFile(filename string, rc io.ReadCloser) (err error) {
+	defer func() {
+		if cerr := rc.Close(); cerr != nil && err == nil {
+			err = errors.WithStack(cerr)
+		}
+	}()
+	f, err := os.Create(filename)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = errors.WithStack(cerr)
+		}
+	}()
+	if _, err := io.Copy(f, rc); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
@@ -1,24 +1,70 @@
 package common
 
 import (
+	"bytes"
+	"encoding/hex"
 	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
 )
 
 type BuildConfig struct {
-	BuildProduct   string
-	RootHash       []byte
-	EncodingHashes [][]byte
+	BuildName       string // Optional
+	BuildProduct    string
+	RootHash        []byte
+	EncodingHashes  [][]byte
+	InstallHashes   [][]byte // Optional, content hash then optionally encoded hash
+	DownloadHashes  [][]byte // Optional, content hash then optionally encoded hash
+	PatchHash       []byte   // Optional, hash of the patch manifest
+	PatchConfigHash []byte   // Optional
 }
 
 func ParseBuildConfig(r io.Reader) (BuildConfig, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return BuildConfig{}, errors.WithStack(err)
+	}
 	buildProduct, root, encoding := "build-product", "root", "encoding"
-	values, hashes, err := parseConfig(r, []string{buildProduct}, []string{root, encoding})
+	_, hashes, err := parseConfig(bytes.NewReader(b), []string{buildProduct}, []string{root, encoding})
+	if err != nil {
+		return BuildConfig{}, err
+	}
+	// Optional keys.
+	values, err := parseConfigValues(bytes.NewReader(b))
 	if err != nil {
 		return BuildConfig{}, err
 	}
+	var installHashes, downloadHashes [][]byte
+	if install, ok := values["install"]; ok {
+		if installHashes, err = parseHashes(install); err != nil {
+			return BuildConfig{}, err
+		}
+	}
+	if download, ok := values["download"]; ok {
+		if downloadHashes, err = parseHashes(download); err != nil {
+			return BuildConfig{}, err
+		}
+	}
+	var patchHash, patchConfigHash []byte
+	if patch, ok := values["patch"]; ok {
+		if patchHash, err = hex.DecodeString(patch); err != nil {
+			return BuildConfig{}, errors.WithStack(errors.New("invalid config"))
+		}
+	}
+	if patchConfig, ok := values["patch-config"]; ok {
+		if patchConfigHash, err = hex.DecodeString(patchConfig); err != nil {
+			return BuildConfig{}, errors.WithStack(errors.New("invalid config"))
+		}
+	}
 	return BuildConfig{
-		BuildProduct:   values[buildProduct],
-		RootHash:       hashes[root][0],
-		EncodingHashes: hashes[encoding],
+		BuildName:       values["build-name"],
+		BuildProduct:    values[buildProduct],
+		RootHash:        hashes[root][0],
+		EncodingHashes:  hashes[encoding],
+		InstallHashes:   installHashes,
+		DownloadHashes:  downloadHashes,
+		PatchHash:       patchHash,
+		PatchConfigHash: patchConfigHash,
 	}, nil
 }
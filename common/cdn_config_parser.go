@@ -1,18 +1,38 @@
 package common
 
 import (
+	"bytes"
 	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
 )
 
 type CdnConfig struct {
-	ArchivesHashes [][]byte
+	ArchivesHashes      [][]byte
+	PatchArchivesHashes [][]byte // Optional
 }
 
 func ParseCdnConfig(r io.Reader) (CdnConfig, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return CdnConfig{}, errors.WithStack(err)
+	}
 	archives := "archives"
-	_, hashes, err := parseConfig(r, nil, []string{archives})
+	_, hashes, err := parseConfig(bytes.NewReader(b), nil, []string{archives})
 	if err != nil {
 		return CdnConfig{}, err
 	}
-	return CdnConfig{hashes[archives]}, nil
+	// Optional keys.
+	values, err := parseConfigValues(bytes.NewReader(b))
+	if err != nil {
+		return CdnConfig{}, err
+	}
+	var patchArchivesHashes [][]byte
+	if patchArchives, ok := values["patch-archives"]; ok {
+		if patchArchivesHashes, err = parseHashes(patchArchives); err != nil {
+			return CdnConfig{}, err
+		}
+	}
+	return CdnConfig{hashes[archives], patchArchivesHashes}, nil
 }
@@ -0,0 +1,30 @@
+package common
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCdnConfig(t *testing.T) {
+	cfg, err := ParseCdnConfig(strings.NewReader(`# CDN Configuration
+
+archives = 0123 4567
+archive-group = 89ab
+patch-archives = cdef
+`))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	assert.Equal(t, [][]byte{{0x01, 0x23}, {0x45, 0x67}}, cfg.ArchivesHashes)
+	assert.Equal(t, [][]byte{{0xcd, 0xef}}, cfg.PatchArchivesHashes)
+
+	if cfg, err = ParseCdnConfig(strings.NewReader("archives = 0123\n")); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	assert.Nil(t, cfg.PatchArchivesHashes)
+	if _, err := ParseCdnConfig(strings.NewReader("patch-archives = cdef\n")); err == nil {
+		t.Fatal("expected missing archives error")
+	}
+}
@@ -36,17 +36,11 @@ func parseConfig(r io.Reader, keys []string, hashesKeys []string) (map[string]st
 		}
 		if _, ok := hashesKeysCheck[kv[0]]; ok {
 			delete(hashesKeysCheck, kv[0])
-			hashesStr := strings.Split(kv[1], " ")
-			if len(hashesStr) == 0 {
-				return nil, nil, errors.WithStack(errors.New("invalid config"))
-			}
-			for _, hashStr := range hashesStr {
-				hash, err := hex.DecodeString(hashStr)
-				if err != nil {
-					return nil, nil, errors.WithStack(errors.New("invalid config"))
-				}
-				hashesLookup[kv[0]] = append(hashesLookup[kv[0]], hash)
+			hashes, err := parseHashes(kv[1])
+			if err != nil {
+				return nil, nil, err
 			}
+			hashesLookup[kv[0]] = hashes
 		}
 
 	}
@@ -58,3 +52,34 @@ func parseConfig(r io.Reader, keys []string, hashesKeys []string) (map[string]st
 	}
 	return keysLookup, hashesLookup, nil
 }
+
+// parseConfigValues returns the raw value of every key of the config.
+// Unlike parseConfig, no key is required.
+func parseConfigValues(r io.Reader) (map[string]string, error) {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		kv := strings.Split(scanner.Text(), " = ")
+		if len(kv) != 2 {
+			continue
+		}
+		values[kv[0]] = kv[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return values, nil
+}
+
+// parseHashes parses hex encoded hashes separated by space characters.
+func parseHashes(value string) ([][]byte, error) {
+	var hashes [][]byte
+	for _, hashStr := range strings.Split(value, " ") {
+		hash, err := hex.DecodeString(hashStr)
+		if err != nil {
+			return nil, errors.WithStack(errors.New("invalid config"))
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
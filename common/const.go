@@ -23,6 +23,7 @@ func NGDPCdnsURL(app, region string) string {
 const (
 	PathTypeConfig = "config"
 	PathTypeData   = "data"
+	PathTypePatch  = "patch"
 )
 
 func Url(cdnHost, cdnPath string, pathType string, hash []byte, index bool) (string, error) {
@@ -40,3 +41,10 @@ func Url(cdnHost, cdnPath string, pathType string, hash []byte, index bool) (str
 func CleanPath(path string) string {
 	return strings.Replace(path, "\\", "/", -1)
 }
+
+// IsLocale reports whether s is a locale code such as "enUS" or "frFR".
+func IsLocale(s string) bool {
+	return len(s) == 4 &&
+		s[0] >= 'a' && s[0] <= 'z' && s[1] >= 'a' && s[1] <= 'z' &&
+		s[2] >= 'A' && s[2] <= 'Z' && s[3] >= 'A' && s[3] <= 'Z'
+}
@@ -0,0 +1,130 @@
+package common
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+type DownloadHeader struct {
+	Signature    uint16
+	Version      uint8
+	EHashSize    uint8
+	HasChecksum  uint8
+	EntryCount   uint32
+	TagCount     uint16
+	FlagSize     uint8 // version 2 and later
+	BasePriority int8  // version 3 and later
+	Unknown      [3]uint8
+}
+
+type DownloadEntry struct {
+	EncodedHash []uint8
+	FileSize    uint64 // encoded size, 40 bits
+	Priority    int    // lower values are downloaded first, BasePriority already subtracted
+	Checksum    uint32 // only set if HasChecksum is set
+	Flags       []uint8
+	Tags        []string
+}
+
+// Download lists the encoded files downloaded by the launcher and their priority.
+// Files with the lowest priority are required to start playing, the others are downloaded in the background.
+type Download struct {
+	Header  DownloadHeader
+	Entries []DownloadEntry
+	Tags    []InstallTag
+}
+
+// Filter returns the entries with the given tags sorted by priority.
+// Tags of the same type are alternatives and an entry must have at least one of them.
+// Tags of different types are all required.
+// An error is returned if a tag is unknown.
+func (d *Download) Filter(tags ...string) ([]DownloadEntry, error) {
+	match, err := matchTags(d.Tags, tags)
+	if err != nil {
+		return nil, err
+	}
+	entries := []DownloadEntry{}
+	for i, entry := range d.Entries {
+		if match(i) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Priority < entries[j].Priority })
+	return entries, nil
+}
+
+func ParseDownload(r io.Reader) (*Download, error) {
+	br := bufio.NewReader(r)
+	h := DownloadHeader{}
+	for _, v := range []interface{}{&h.Signature, &h.Version, &h.EHashSize, &h.HasChecksum, &h.EntryCount, &h.TagCount} {
+		if err := binary.Read(br, binary.BigEndian, v); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	if h.Signature != 0x444c { // DL
+		return nil, errors.WithStack(errors.New("invalid download header"))
+	}
+	if h.Version < 1 || h.Version > 3 {
+		return nil, errors.WithStack(errors.Errorf("unsupported download version %d", h.Version))
+	}
+	if h.Version >= 2 {
+		if err := binary.Read(br, binary.BigEndian, &h.FlagSize); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	if h.Version >= 3 {
+		if err := binary.Read(br, binary.BigEndian, &h.BasePriority); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if _, err := io.ReadFull(br, h.Unknown[:]); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	download := &Download{Header: h}
+	for i := uint32(0); i < h.EntryCount; i++ {
+		entry := DownloadEntry{}
+		entry.EncodedHash = make([]uint8, h.EHashSize)
+		if _, err := io.ReadFull(br, entry.EncodedHash); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		var err error
+		if entry.FileSize, err = readUint40(br); err != nil {
+			return nil, err
+		}
+		var priority int8
+		if err := binary.Read(br, binary.BigEndian, &priority); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		// Subtracting BasePriority may not fit in an int8.
+		entry.Priority = int(priority) - int(h.BasePriority)
+		if h.HasChecksum != 0 {
+			if err := binary.Read(br, binary.BigEndian, &entry.Checksum); err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+		if h.FlagSize > 0 {
+			entry.Flags = make([]uint8, h.FlagSize)
+			if _, err := io.ReadFull(br, entry.Flags); err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+		download.Entries = append(download.Entries, entry)
+	}
+	tags, err := parseTags(br, int(h.TagCount), int(h.EntryCount))
+	if err != nil {
+		return nil, err
+	}
+	download.Tags = tags
+	for i := range download.Entries {
+		for _, tag := range tags {
+			if tag.Has(i) {
+				download.Entries[i].Tags = append(download.Entries[i].Tags, tag.Name)
+			}
+		}
+	}
+	return download, nil
+}
@@ -0,0 +1,91 @@
+package common
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDownload(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		entry  func(ekey byte, size byte, priority byte) []byte
+	}{
+		{
+			name:   "version 1",
+			header: []byte{'D', 'L', 1, 2, 0, 0, 0, 0, 3, 0, 2},
+			entry: func(ekey, size, priority byte) []byte {
+				return []byte{ekey, ekey, 0, 0, 0, 0, size, priority}
+			},
+		},
+		{
+			name:   "version 2 with checksums",
+			header: []byte{'D', 'L', 2, 2, 1, 0, 0, 0, 3, 0, 2, 1},
+			entry: func(ekey, size, priority byte) []byte {
+				return []byte{ekey, ekey, 0, 0, 0, 0, size, priority, 0, 0, 0, ekey, 0}
+			},
+		},
+		{
+			name:   "version 3",
+			header: []byte{'D', 'L', 3, 2, 0, 0, 0, 0, 3, 0, 2, 0, 0xff, 0, 0, 0}, // base priority -1
+			entry: func(ekey, size, priority byte) []byte {
+				return []byte{ekey, ekey, 0, 0, 0, 0, size, priority - 1}
+			},
+		},
+	}
+	for _, test := range tests {
+		b := bytes.Join([][]byte{
+			test.header,
+			test.entry(0xaa, 10, 2),
+			test.entry(0xbb, 20, 0),
+			test.entry(0xcc, 30, 1),
+			[]byte("Windows\x00"), {0, 1, 0xc0},
+			[]byte("OSX\x00"), {0, 1, 0xa0},
+		}, nil)
+		download, err := ParseDownload(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("%s: %+v", test.name, err)
+		}
+		assert.Equal(t, 3, len(download.Entries), test.name)
+		entry := download.Entries[0]
+		assert.Equal(t, []uint8{0xaa, 0xaa}, entry.EncodedHash, test.name)
+		assert.Equal(t, uint64(10), entry.FileSize, test.name)
+		assert.Equal(t, 2, entry.Priority, test.name)
+		assert.Equal(t, []string{"Windows", "OSX"}, entry.Tags, test.name)
+		if download.Header.HasChecksum != 0 {
+			assert.Equal(t, uint32(0xaa), entry.Checksum, test.name)
+		}
+
+		entries, err := download.Filter("Windows")
+		if err != nil {
+			t.Fatalf("%s: %+v", test.name, err)
+		}
+		assert.Equal(t, 2, len(entries), test.name)
+		assert.Equal(t, []uint8{0xbb, 0xbb}, entries[0].EncodedHash, test.name)
+		assert.Equal(t, []uint8{0xaa, 0xaa}, entries[1].EncodedHash, test.name)
+	}
+}
+
+func TestParseDownloadPriorityOverflow(t *testing.T) {
+	b := bytes.Join([][]byte{
+		{'D', 'L', 3, 2, 0, 0, 0, 0, 2, 0, 0, 0, 1, 0, 0, 0}, // base priority 1
+		{0xaa, 0xaa, 0, 0, 0, 0, 10, 0x7f},                   // priority 127
+		{0xbb, 0xbb, 0, 0, 0, 0, 20, 0x80},                   // priority -128
+	}, nil)
+	download, err := ParseDownload(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	assert.Equal(t, 2, len(download.Entries))
+	assert.Equal(t, 126, download.Entries[0].Priority)
+	assert.Equal(t, -129, download.Entries[1].Priority)
+
+	entries, err := download.Filter()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	assert.Equal(t, []uint8{0xbb, 0xbb}, entries[0].EncodedHash)
+	assert.Equal(t, []uint8{0xaa, 0xaa}, entries[1].EncodedHash)
+}
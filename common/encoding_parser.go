@@ -4,29 +4,29 @@ import (
 	"bytes"
 	"crypto/md5"
 	"encoding/binary"
-	"encoding/hex"
 	"io"
 
 	"github.com/pkg/errors"
 )
 
 type EncodingCPageEntry struct {
-	KeyCount uint16
-	FileSize uint32
+	KeyCount uint8
+	FileSize uint64 // decoded size, 40 bits
 	Ckey     []uint8
 	Ekey     [][]uint8
 }
 
+type EncodingEPageEntry struct {
+	Ekey        []uint8
+	ESpecIndex  uint32
+	EncodedSize uint64 // 40 bits
+}
+
 type EncodingPageIndex struct {
 	Hash     []uint8
 	Checksum [0x10]uint8
 }
 
-type EncodingCTableEntry struct {
-	Index   EncodingPageIndex
-	Entries []EncodingCPageEntry
-}
-
 type EncodingHeader struct {
 	Signature      uint16
 	Version        uint8
@@ -40,43 +40,96 @@ type EncodingHeader struct {
 	EspecBlockSize uint32
 }
 
-func ParseEncoding(r io.Reader) (map[string][][]byte, error) {
-	h := &EncodingHeader{}
-	if err := binary.Read(r, binary.BigEndian, h); err != nil {
+// Encoding relates content hashes to encoded hashes and
+// encoded hashes to their encoding specification (ESpec).
+type Encoding struct {
+	Header EncodingHeader
+	ESpecs []string
+
+	cEntries map[string]EncodingCPageEntry
+	eEntries map[string]EncodingEPageEntry
+}
+
+// Content returns the entry of the content hash ckey.
+func (e *Encoding) Content(ckey []byte) (EncodingCPageEntry, bool) {
+	entry, ok := e.cEntries[string(ckey)]
+	return entry, ok
+}
+
+// EncodedHashes returns the encoded hashes of the content hash ckey.
+func (e *Encoding) EncodedHashes(ckey []byte) ([][]byte, bool) {
+	entry, ok := e.cEntries[string(ckey)]
+	if !ok || len(entry.Ekey) == 0 {
+		return nil, false
+	}
+	return entry.Ekey, true
+}
+
+// Encoded returns the entry of the encoded hash ekey.
+func (e *Encoding) Encoded(ekey []byte) (EncodingEPageEntry, bool) {
+	entry, ok := e.eEntries[string(ekey)]
+	return entry, ok
+}
+
+// ESpec returns the encoding specification of the encoded hash ekey.
+func (e *Encoding) ESpec(ekey []byte) (string, bool) {
+	entry, ok := e.eEntries[string(ekey)]
+	if !ok || int(entry.ESpecIndex) >= len(e.ESpecs) {
+		return "", false
+	}
+	return e.ESpecs[entry.ESpecIndex], true
+}
+
+// ContentHashes returns all content hashes.
+func (e *Encoding) ContentHashes() [][]byte {
+	ckeys := make([][]byte, 0, len(e.cEntries))
+	for _, entry := range e.cEntries {
+		ckeys = append(ckeys, entry.Ckey)
+	}
+	return ckeys
+}
+
+func ParseEncoding(r io.Reader) (*Encoding, error) {
+	h := EncodingHeader{}
+	if err := binary.Read(r, binary.BigEndian, &h); err != nil {
 		return nil, errors.WithStack(err)
 	}
 	if h.Signature != 0x454e {
 		return nil, errors.WithStack(errors.New("invalid encoding header"))
 	}
-	if _, err := io.ReadFull(r, make([]uint8, h.EspecBlockSize)); err != nil {
+	especBlock := make([]uint8, h.EspecBlockSize)
+	if _, err := io.ReadFull(r, especBlock); err != nil {
 		return nil, errors.WithStack(err)
 	}
-	var cPageIndices []EncodingPageIndex
-	for i := uint32(0); i < h.CPageCount; i++ {
-		idx := EncodingPageIndex{}
-		idx.Hash = make([]uint8, h.CHashSize)
-		if err := binary.Read(r, binary.BigEndian, &idx.Hash); err != nil {
-			return nil, errors.WithStack(err)
-		}
-		if err := binary.Read(r, binary.BigEndian, &idx.Checksum); err != nil {
-			return nil, errors.WithStack(err)
+	especs := []string{}
+	for _, espec := range bytes.Split(especBlock, []byte{0}) {
+		if len(espec) == 0 {
+			continue
 		}
-		cPageIndices = append(cPageIndices, idx)
+		especs = append(especs, string(espec))
+	}
+	encoding := &Encoding{
+		Header:   h,
+		ESpecs:   especs,
+		cEntries: map[string]EncodingCPageEntry{},
+		eEntries: map[string]EncodingEPageEntry{},
+	}
+
+	//
+	// CKey pages
+	//
+	cPageIndices, err := parsePageIndices(r, h.CPageCount, h.CHashSize)
+	if err != nil {
+		return nil, err
 	}
-	tableEntries := []EncodingCTableEntry{}
 	for _, idx := range cPageIndices {
-		CTableData := make([]byte, int(h.CPageSize)*1024)
-		if err := binary.Read(r, binary.BigEndian, &CTableData); err != nil {
-			return nil, errors.WithStack(err)
+		page, err := readPage(r, h.CPageSize, idx)
+		if err != nil {
+			return nil, err
 		}
-		if hash := md5.Sum(CTableData); bytes.Compare(hash[:], idx.Checksum[:]) != 0 {
-			return nil, errors.WithStack(errors.New("encoding file invalid checksum"))
-		}
-		entries := []EncodingCPageEntry{}
-		CTableDataBuf := bytes.NewBuffer(CTableData)
-		for i := uint32(0); ; /*until EOF or until padding (cEntry.KeyCount == 0)*/ i++ {
+		for /*until EOF or until padding (KeyCount == 0)*/ {
 			cEntry := EncodingCPageEntry{}
-			if err := binary.Read(CTableDataBuf, binary.LittleEndian, &cEntry.KeyCount); err != nil {
+			if err := binary.Read(page, binary.BigEndian, &cEntry.KeyCount); err != nil {
 				if err == io.EOF {
 					break
 				}
@@ -86,31 +139,93 @@ func ParseEncoding(r io.Reader) (map[string][][]byte, error) {
 				//a page is zero padded once entries have filled it
 				break
 			}
-			if err := binary.Read(CTableDataBuf, binary.BigEndian, &cEntry.FileSize); err != nil {
-				return nil, errors.WithStack(err)
+			if cEntry.FileSize, err = readUint40(page); err != nil {
+				return nil, err
 			}
 			cEntry.Ckey = make([]uint8, h.CHashSize)
-			if err := binary.Read(CTableDataBuf, binary.BigEndian, &cEntry.Ckey); err != nil {
+			if _, err := io.ReadFull(page, cEntry.Ckey); err != nil {
 				return nil, errors.WithStack(err)
 			}
-			for i := uint16(0); i < cEntry.KeyCount; i++ {
+			for i := uint8(0); i < cEntry.KeyCount; i++ {
 				ekey := make([]uint8, h.EHashSize)
-				if err := binary.Read(CTableDataBuf, binary.BigEndian, &ekey); err != nil {
+				if _, err := io.ReadFull(page, ekey); err != nil {
 					return nil, errors.WithStack(err)
 				}
 				cEntry.Ekey = append(cEntry.Ekey, ekey)
 			}
-			entries = append(entries, cEntry)
+			encoding.cEntries[string(cEntry.Ckey)] = cEntry
+		}
+	}
+
+	//
+	// EKey pages
+	//
+	ePageIndices, err := parsePageIndices(r, h.EPageCount, h.EHashSize)
+	if err != nil {
+		return nil, err
+	}
+	eEntrySize := int(h.EHashSize) + 4 + 5
+	for _, idx := range ePageIndices {
+		page, err := readPage(r, h.EPageSize, idx)
+		if err != nil {
+			return nil, err
+		}
+		for page.Len() >= eEntrySize {
+			eEntry := EncodingEPageEntry{}
+			eEntry.Ekey = make([]uint8, h.EHashSize)
+			if _, err := io.ReadFull(page, eEntry.Ekey); err != nil {
+				return nil, errors.WithStack(err)
+			}
+			if bytes.Count(eEntry.Ekey, []byte{0}) == len(eEntry.Ekey) {
+				//a page is zero padded once entries have filled it
+				break
+			}
+			if err := binary.Read(page, binary.BigEndian, &eEntry.ESpecIndex); err != nil {
+				return nil, errors.WithStack(err)
+			}
+			if eEntry.EncodedSize, err = readUint40(page); err != nil {
+				return nil, err
+			}
+			encoding.eEntries[string(eEntry.Ekey)] = eEntry
 		}
-		tableEntries = append(tableEntries, EncodingCTableEntry{Index: idx, Entries: entries})
 	}
+	return encoding, nil
+}
 
-	//create a simple map ContentHash => EncodedHashes
-	lookup := map[string][][]byte{}
-	for _, tableEntry := range tableEntries {
-		for _, entry := range tableEntry.Entries {
-			lookup[hex.EncodeToString(entry.Ckey)] = entry.Ekey
+// parsePageIndices parses count page indices whose first hash is hashSize bytes long.
+func parsePageIndices(r io.Reader, count uint32, hashSize uint8) ([]EncodingPageIndex, error) {
+	var indices []EncodingPageIndex
+	for i := uint32(0); i < count; i++ {
+		idx := EncodingPageIndex{}
+		idx.Hash = make([]uint8, hashSize)
+		if _, err := io.ReadFull(r, idx.Hash); err != nil {
+			return nil, errors.WithStack(err)
 		}
+		if _, err := io.ReadFull(r, idx.Checksum[:]); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		indices = append(indices, idx)
+	}
+	return indices, nil
+}
+
+// readPage reads a page of pageSize KB and validates its checksum.
+func readPage(r io.Reader, pageSize uint16, idx EncodingPageIndex) (*bytes.Buffer, error) {
+	page := make([]byte, int(pageSize)*1024)
+	if _, err := io.ReadFull(r, page); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if hash := md5.Sum(page); bytes.Compare(hash[:], idx.Checksum[:]) != 0 {
+		return nil, errors.WithStack(errors.New("encoding file invalid checksum"))
+	}
+	return bytes.NewBuffer(page), nil
+}
+
+// readUint40 reads a big endian 40 bits unsigned integer.
+func readUint40(r io.Reader) (uint64, error) {
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(r, b[3:]); err != nil {
+		return 0, errors.WithStack(err)
 	}
-	return lookup, nil
+	return binary.BigEndian.Uint64(b), nil
 }
@@ -0,0 +1,103 @@
+package common
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testEncodingFile(corruptEPage bool) []byte {
+	ckey := bytes.Repeat([]byte{0xc1}, 16)
+	ekeys := [][]byte{bytes.Repeat([]byte{0xe1}, 16), bytes.Repeat([]byte{0xe2}, 16)}
+	especs := []byte("n\x00b:{256K*=z}\x00")
+
+	cPage := make([]byte, 1024)
+	cPage[0] = 2                              // key count
+	cPage[1] = 1                              // file size, high byte
+	binary.BigEndian.PutUint32(cPage[2:], 42) // file size, low bytes
+	copy(cPage[6:], ckey)
+	copy(cPage[22:], ekeys[0])
+	copy(cPage[38:], ekeys[1])
+
+	ePage := make([]byte, 1024)
+	copy(ePage[0:], ekeys[0])
+	binary.BigEndian.PutUint32(ePage[16:], 1) // espec index
+	binary.BigEndian.PutUint32(ePage[21:], 100)
+	copy(ePage[25:], ekeys[1])
+	binary.BigEndian.PutUint32(ePage[41:], 0)
+	binary.BigEndian.PutUint32(ePage[46:], 50)
+
+	cChecksum := md5.Sum(cPage)
+	eChecksum := md5.Sum(ePage)
+	if corruptEPage {
+		eChecksum[0]++
+	}
+	b := bytes.NewBuffer(nil)
+	binary.Write(b, binary.BigEndian, EncodingHeader{
+		Signature:      0x454e,
+		Version:        1,
+		CHashSize:      16,
+		EHashSize:      16,
+		CPageSize:      1,
+		EPageSize:      1,
+		CPageCount:     1,
+		EPageCount:     1,
+		EspecBlockSize: uint32(len(especs)),
+	})
+	b.Write(especs)
+	b.Write(ckey)
+	b.Write(cChecksum[:])
+	b.Write(cPage)
+	b.Write(ekeys[0])
+	b.Write(eChecksum[:])
+	b.Write(ePage)
+	b.Write([]byte("n")) // espec of the encoding file itself
+	return b.Bytes()
+}
+
+func TestParseEncoding(t *testing.T) {
+	encoding, err := ParseEncoding(bytes.NewReader(testEncodingFile(false)))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	assert.Equal(t, []string{"n", "b:{256K*=z}"}, encoding.ESpecs)
+
+	ckey := bytes.Repeat([]byte{0xc1}, 16)
+	ekey1, ekey2 := bytes.Repeat([]byte{0xe1}, 16), bytes.Repeat([]byte{0xe2}, 16)
+	cEntry, ok := encoding.Content(ckey)
+	if !ok {
+		t.Fatal("ckey not found")
+	}
+	assert.Equal(t, uint64(1<<32+42), cEntry.FileSize)
+	assert.Equal(t, [][]byte{ekey1, ekey2}, cEntry.Ekey)
+	ekeys, ok := encoding.EncodedHashes(ckey)
+	assert.True(t, ok)
+	assert.Equal(t, [][]byte{ekey1, ekey2}, ekeys)
+	assert.Equal(t, [][]byte{ckey}, encoding.ContentHashes())
+
+	eEntry, ok := encoding.Encoded(ekey1)
+	if !ok {
+		t.Fatal("ekey not found")
+	}
+	assert.Equal(t, uint64(100), eEntry.EncodedSize)
+	espec, ok := encoding.ESpec(ekey1)
+	assert.True(t, ok)
+	assert.Equal(t, "b:{256K*=z}", espec)
+	espec, ok = encoding.ESpec(ekey2)
+	assert.True(t, ok)
+	assert.Equal(t, "n", espec)
+
+	_, ok = encoding.Content(ekey1)
+	assert.False(t, ok)
+	_, ok = encoding.ESpec(ckey)
+	assert.False(t, ok)
+}
+
+func TestParseEncodingInvalidChecksum(t *testing.T) {
+	if _, err := ParseEncoding(bytes.NewReader(testEncodingFile(true))); err == nil {
+		t.Fatal("expected invalid checksum error")
+	}
+}
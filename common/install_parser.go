@@ -0,0 +1,168 @@
+package common
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+type InstallHeader struct {
+	Signature  uint16
+	Version    uint8
+	HashSize   uint8
+	TagCount   uint16
+	EntryCount uint32
+}
+
+// InstallTag tags a subset of the entries of the install or download manifest.
+// Type is product specific, usually 1 for platforms, 2 for architectures, 3 for locales and 4 for regions.
+type InstallTag struct {
+	Name string
+	Type uint16
+	Mask []uint8 // bit i, from the most significant bit, is set if entry i is tagged
+}
+
+// Has returns true if the entry at index i is tagged.
+func (t InstallTag) Has(i int) bool {
+	if i < 0 || i/8 >= len(t.Mask) {
+		return false
+	}
+	return t.Mask[i/8]&(0x80>>uint(i%8)) != 0
+}
+
+type InstallEntry struct {
+	Name        string
+	ContentHash []uint8
+	Size        uint32
+	Tags        []string
+}
+
+// Install lists the files installed by the launcher.
+type Install struct {
+	Header  InstallHeader
+	Tags    []InstallTag
+	Entries []InstallEntry
+}
+
+// Filter returns the entries with the given tags.
+// Tags of the same type are alternatives and an entry must have at least one of them.
+// Tags of different types are all required.
+// An error is returned if a tag is unknown.
+func (in *Install) Filter(tags ...string) ([]InstallEntry, error) {
+	match, err := matchTags(in.Tags, tags)
+	if err != nil {
+		return nil, err
+	}
+	entries := []InstallEntry{}
+	for i, entry := range in.Entries {
+		if match(i) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// matchTags returns a function reporting whether the entry at index i has the tags named names.
+// Tags of the same type are alternatives and tags of different types are all required.
+func matchTags(tags []InstallTag, names []string) (func(i int) bool, error) {
+	byName := map[string]int{}
+	for i, tag := range tags {
+		byName[tag.Name] = i
+	}
+	byType := map[uint16][]InstallTag{}
+	for _, name := range names {
+		i, ok := byName[name]
+		if !ok {
+			return nil, errors.WithStack(errors.Errorf("unknown tag %q", name))
+		}
+		byType[tags[i].Type] = append(byType[tags[i].Type], tags[i])
+	}
+	return func(i int) bool {
+		for _, alternatives := range byType {
+			has := false
+			for _, tag := range alternatives {
+				if tag.Has(i) {
+					has = true
+					break
+				}
+			}
+			if !has {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func ParseInstall(r io.Reader) (*Install, error) {
+	br := bufio.NewReader(r)
+	h := InstallHeader{}
+	if err := binary.Read(br, binary.BigEndian, &h); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if h.Signature != 0x494e { // IN
+		return nil, errors.WithStack(errors.New("invalid install header"))
+	}
+	if h.Version != 1 {
+		return nil, errors.WithStack(errors.Errorf("unsupported install version %d", h.Version))
+	}
+	install := &Install{Header: h}
+	tags, err := parseTags(br, int(h.TagCount), int(h.EntryCount))
+	if err != nil {
+		return nil, err
+	}
+	install.Tags = tags
+	for i := 0; i < int(h.EntryCount); i++ {
+		entry := InstallEntry{}
+		var err error
+		if entry.Name, err = readCString(br); err != nil {
+			return nil, err
+		}
+		entry.ContentHash = make([]uint8, h.HashSize)
+		if _, err := io.ReadFull(br, entry.ContentHash); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if err := binary.Read(br, binary.BigEndian, &entry.Size); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for _, tag := range install.Tags {
+			if tag.Has(i) {
+				entry.Tags = append(entry.Tags, tag.Name)
+			}
+		}
+		install.Entries = append(install.Entries, entry)
+	}
+	return install, nil
+}
+
+// parseTags parses count tags of entryCount entries.
+func parseTags(r *bufio.Reader, count, entryCount int) ([]InstallTag, error) {
+	var tags []InstallTag
+	for i := 0; i < count; i++ {
+		tag := InstallTag{}
+		var err error
+		if tag.Name, err = readCString(r); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(r, binary.BigEndian, &tag.Type); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		tag.Mask = make([]uint8, (entryCount+7)/8)
+		if _, err := io.ReadFull(r, tag.Mask); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// readCString reads a null terminated string.
+func readCString(r *bufio.Reader) (string, error) {
+	s, err := r.ReadString(0)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return s[:len(s)-1], nil
+}
@@ -0,0 +1,58 @@
+package common
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testInstallFile() []byte {
+	return bytes.Join([][]byte{
+		{'I', 'N', 1, 2, 0, 3, 0, 0, 0, 3}, // header: 3 tags, 3 entries
+		[]byte("Windows\x00"), {0, 1, 0xc0},
+		[]byte("OSX\x00"), {0, 1, 0xa0},
+		[]byte("enUS\x00"), {0, 3, 0x80},
+		[]byte("Warcraft III.exe\x00"), {0xaa, 0xaa}, {0, 0, 0, 42},
+		[]byte("Warcraft III Launcher.exe\x00"), {0xbb, 0xbb}, {0, 0, 1, 0},
+		[]byte("Warcraft III.app\x00"), {0xcc, 0xcc}, {0, 0, 0, 1},
+	}, nil)
+}
+
+func TestParseInstall(t *testing.T) {
+	install, err := ParseInstall(bytes.NewReader(testInstallFile()))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	assert.Equal(t, 3, len(install.Tags))
+	assert.Equal(t, InstallTag{Name: "enUS", Type: 3, Mask: []uint8{0x80}}, install.Tags[2])
+	assert.Equal(t, []InstallEntry{
+		{Name: "Warcraft III.exe", ContentHash: []uint8{0xaa, 0xaa}, Size: 42, Tags: []string{"Windows", "OSX", "enUS"}},
+		{Name: "Warcraft III Launcher.exe", ContentHash: []uint8{0xbb, 0xbb}, Size: 256, Tags: []string{"Windows"}},
+		{Name: "Warcraft III.app", ContentHash: []uint8{0xcc, 0xcc}, Size: 1, Tags: []string{"OSX"}},
+	}, install.Entries)
+
+	names := func(entries []InstallEntry, err error) []string {
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		names := []string{}
+		for _, e := range entries {
+			names = append(names, e.Name)
+		}
+		return names
+	}
+	assert.Equal(t, []string{"Warcraft III.exe", "Warcraft III Launcher.exe", "Warcraft III.app"}, names(install.Filter()))
+	assert.Equal(t, []string{"Warcraft III.exe", "Warcraft III Launcher.exe"}, names(install.Filter("Windows")))
+	assert.Equal(t, []string{"Warcraft III.exe", "Warcraft III Launcher.exe", "Warcraft III.app"}, names(install.Filter("Windows", "OSX")))
+	assert.Equal(t, []string{"Warcraft III.exe"}, names(install.Filter("Windows", "enUS")))
+	if _, err := install.Filter("frFR"); err == nil {
+		t.Fatal("expected unknown tag error")
+	}
+}
+
+func TestParseInstallInvalidHeader(t *testing.T) {
+	if _, err := ParseInstall(bytes.NewReader([]byte{'D', 'L', 1, 16, 0, 0, 0, 0, 0, 0})); err == nil {
+		t.Fatal("expected invalid header error")
+	}
+}
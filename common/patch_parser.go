@@ -0,0 +1,276 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type PatchHeader struct {
+	Signature     uint16
+	Version       uint8
+	FileKeySize   uint8
+	OldKeySize    uint8
+	PatchKeySize  uint8
+	BlockSizeBits uint8
+	BlockCount    uint16
+	Flags         uint8
+}
+
+type PatchBlock struct {
+	LastFileContentHash []uint8
+	Checksum            [0x10]uint8
+	Offset              uint32
+}
+
+// PatchRecord is a patch upgrading the old file OldEncodedHash.
+type PatchRecord struct {
+	OldEncodedHash []uint8
+	OldSize        uint64 // decoded size, 40 bits
+	PatchHash      []uint8
+	PatchSize      uint32
+	Ordinal        uint8
+}
+
+// PatchEntry lists the patches producing the content ContentHash.
+type PatchEntry struct {
+	ContentHash []uint8
+	Size        uint64 // decoded size, 40 bits
+	Patches     []PatchRecord
+}
+
+// PatchManifest relates content hashes to the patches producing them from older files.
+type PatchManifest struct {
+	Header PatchHeader
+	Blocks []PatchBlock
+
+	entries map[string]PatchEntry
+}
+
+// Entry returns the patches producing the content hash ckey.
+func (p *PatchManifest) Entry(ckey []byte) (PatchEntry, bool) {
+	entry, ok := p.entries[string(ckey)]
+	return entry, ok
+}
+
+// ContentHashes returns all content hashes that can be produced by a patch.
+func (p *PatchManifest) ContentHashes() [][]byte {
+	ckeys := make([][]byte, 0, len(p.entries))
+	for _, entry := range p.entries {
+		ckeys = append(ckeys, entry.ContentHash)
+	}
+	return ckeys
+}
+
+// ParsePatchManifest parses the "PA" patch manifest referenced by the patch key of build configs.
+func ParsePatchManifest(r io.Reader) (*PatchManifest, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	br := bytes.NewReader(b)
+	h := PatchHeader{}
+	if err := binary.Read(br, binary.BigEndian, &h); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if h.Signature != 0x5041 { // PA
+		return nil, errors.WithStack(errors.New("invalid patch manifest header"))
+	}
+	if h.Version < 1 || h.Version > 2 {
+		return nil, errors.WithStack(errors.Errorf("unsupported patch manifest version %d", h.Version))
+	}
+	if h.Flags&2 != 0 {
+		// The extended header describes the encoding file: ckey, ekey, sizes and espec.
+		if _, err := br.Seek(int64(h.FileKeySize)*2+4+4, io.SeekCurrent); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		var especLen uint8
+		if err := binary.Read(br, binary.BigEndian, &especLen); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if _, err := br.Seek(int64(especLen), io.SeekCurrent); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	manifest := &PatchManifest{Header: h, entries: map[string]PatchEntry{}}
+	for i := uint16(0); i < h.BlockCount; i++ {
+		block := PatchBlock{LastFileContentHash: make([]uint8, h.FileKeySize)}
+		if _, err := io.ReadFull(br, block.LastFileContentHash); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if _, err := io.ReadFull(br, block.Checksum[:]); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if err := binary.Read(br, binary.BigEndian, &block.Offset); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		manifest.Blocks = append(manifest.Blocks, block)
+	}
+	blockSize := 1 << h.BlockSizeBits
+	for _, block := range manifest.Blocks {
+		if int(block.Offset) > len(b) {
+			return nil, errors.WithStack(errors.New("invalid patch manifest block offset"))
+		}
+		end := int(block.Offset) + blockSize
+		if end > len(b) {
+			end = len(b)
+		}
+		blockB := b[block.Offset:end]
+		if hash := md5.Sum(blockB); hash != block.Checksum {
+			return nil, errors.WithStack(errors.New("patch manifest invalid checksum"))
+		}
+		if err := parsePatchBlock(bytes.NewReader(blockB), h, manifest.entries); err != nil {
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
+
+// parsePatchBlock parses the entries of a block until EOF or until padding (patch count == 0).
+func parsePatchBlock(r io.Reader, h PatchHeader, entries map[string]PatchEntry) error {
+	for {
+		var count uint8
+		if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return errors.WithStack(err)
+		}
+		if count == 0 {
+			return nil
+		}
+		entry := PatchEntry{ContentHash: make([]uint8, h.FileKeySize)}
+		if _, err := io.ReadFull(r, entry.ContentHash); err != nil {
+			return errors.WithStack(err)
+		}
+		var err error
+		if entry.Size, err = readUint40(r); err != nil {
+			return err
+		}
+		for i := uint8(0); i < count; i++ {
+			record := PatchRecord{
+				OldEncodedHash: make([]uint8, h.OldKeySize),
+				PatchHash:      make([]uint8, h.PatchKeySize),
+			}
+			if _, err := io.ReadFull(r, record.OldEncodedHash); err != nil {
+				return errors.WithStack(err)
+			}
+			if record.OldSize, err = readUint40(r); err != nil {
+				return err
+			}
+			if _, err := io.ReadFull(r, record.PatchHash); err != nil {
+				return errors.WithStack(err)
+			}
+			if err := binary.Read(r, binary.BigEndian, &record.PatchSize); err != nil {
+				return errors.WithStack(err)
+			}
+			if err := binary.Read(r, binary.BigEndian, &record.Ordinal); err != nil {
+				return errors.WithStack(err)
+			}
+			entry.Patches = append(entry.Patches, record)
+		}
+		entries[string(entry.ContentHash)] = entry
+	}
+}
+
+// PatchConfigEntry is a patch-entry of a patch config.
+// It describes how to patch the build files such as the encoding, install and download files.
+type PatchConfigEntry struct {
+	Type        string
+	ContentHash []byte
+	ContentSize uint64
+	EncodedHash []byte
+	EncodedSize uint64
+	ESpec       string
+	Patches     []PatchRecord // Ordinal is not set
+}
+
+type PatchConfig struct {
+	PatchHash []byte
+	PatchSize uint64 // Optional
+	Entries   []PatchConfigEntry
+}
+
+// ParsePatchConfig parses a patch config referenced by the patch-config key of build configs.
+// patch-entry values are made of the type, content hash, content size, encoded hash, encoded size
+// and ESpec of the file, followed by the old encoded hash, old content size, patch hash and patch size
+// of each patch.
+func ParsePatchConfig(r io.Reader) (PatchConfig, error) {
+	cfg := PatchConfig{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		kv := strings.Split(scanner.Text(), " = ")
+		if len(kv) != 2 {
+			continue
+		}
+		var err error
+		switch kv[0] {
+		case "patch":
+			cfg.PatchHash, err = hex.DecodeString(kv[1])
+		case "patch-size":
+			cfg.PatchSize, err = strconv.ParseUint(kv[1], 10, 64)
+		case "patch-entry":
+			var entry PatchConfigEntry
+			entry, err = parsePatchConfigEntry(strings.Split(kv[1], " "))
+			cfg.Entries = append(cfg.Entries, entry)
+		}
+		if err != nil {
+			return PatchConfig{}, errors.WithStack(errors.New("invalid patch config"))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return PatchConfig{}, errors.WithStack(err)
+	}
+	if len(cfg.PatchHash) == 0 {
+		return PatchConfig{}, errors.WithStack(errors.New("invalid patch config"))
+	}
+	return cfg, nil
+}
+
+func parsePatchConfigEntry(fields []string) (PatchConfigEntry, error) {
+	if len(fields) < 6 || (len(fields)-6)%4 != 0 {
+		return PatchConfigEntry{}, errors.New("invalid patch-entry")
+	}
+	var err error
+	entry := PatchConfigEntry{Type: fields[0], ESpec: fields[5]}
+	if entry.ContentHash, err = hex.DecodeString(fields[1]); err != nil {
+		return PatchConfigEntry{}, err
+	}
+	if entry.ContentSize, err = strconv.ParseUint(fields[2], 10, 64); err != nil {
+		return PatchConfigEntry{}, err
+	}
+	if entry.EncodedHash, err = hex.DecodeString(fields[3]); err != nil {
+		return PatchConfigEntry{}, err
+	}
+	if entry.EncodedSize, err = strconv.ParseUint(fields[4], 10, 64); err != nil {
+		return PatchConfigEntry{}, err
+	}
+	for i := 6; i < len(fields); i += 4 {
+		record := PatchRecord{}
+		if record.OldEncodedHash, err = hex.DecodeString(fields[i]); err != nil {
+			return PatchConfigEntry{}, err
+		}
+		if record.OldSize, err = strconv.ParseUint(fields[i+1], 10, 64); err != nil {
+			return PatchConfigEntry{}, err
+		}
+		if record.PatchHash, err = hex.DecodeString(fields[i+2]); err != nil {
+			return PatchConfigEntry{}, err
+		}
+		size, err := strconv.ParseUint(fields[i+3], 10, 32)
+		if err != nil {
+			return PatchConfigEntry{}, err
+		}
+		record.PatchSize = uint32(size)
+		entry.Patches = append(entry.Patches, record)
+	}
+	return entry, nil
+}
@@ -0,0 +1,91 @@
+package common
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testPatchManifest(corrupt bool) []byte {
+	block := bytes.NewBuffer(nil)
+	// entry with one patch
+	block.Write([]byte{1, 0xc1, 0xc1, 0, 0, 0, 0, 10})
+	block.Write([]byte{0xe1, 0xe1, 0, 0, 0, 0, 8, 0xb1, 0xb1, 0, 0, 0, 5, 1})
+	// entry with two patches
+	block.Write([]byte{2, 0xc2, 0xc2, 0, 0, 0, 1, 0})
+	block.Write([]byte{0xe2, 0xe2, 0, 0, 0, 0, 200, 0xb2, 0xb2, 0, 0, 0, 50, 1})
+	block.Write([]byte{0xe3, 0xe3, 0, 0, 0, 0, 100, 0xb3, 0xb3, 0, 0, 0, 60, 2})
+	blockB := make([]byte, 64)
+	copy(blockB, block.Bytes())
+	checksum := md5.Sum(blockB)
+	if corrupt {
+		checksum[0]++
+	}
+
+	b := bytes.NewBuffer(nil)
+	b.Write([]byte{'P', 'A', 2, 2, 2, 2, 6, 0, 1, 0}) // 1 block of 64 bytes
+	b.Write([]byte{0xc2, 0xc2})
+	b.Write(checksum[:])
+	binary.Write(b, binary.BigEndian, uint32(b.Len()+4))
+	b.Write(blockB)
+	return b.Bytes()
+}
+
+func TestParsePatchManifest(t *testing.T) {
+	manifest, err := ParsePatchManifest(bytes.NewReader(testPatchManifest(false)))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	assert.Equal(t, 2, len(manifest.ContentHashes()))
+	entry, ok := manifest.Entry([]byte{0xc1, 0xc1})
+	if !ok {
+		t.Fatal("entry not found")
+	}
+	assert.Equal(t, PatchEntry{
+		ContentHash: []uint8{0xc1, 0xc1},
+		Size:        10,
+		Patches: []PatchRecord{
+			{OldEncodedHash: []uint8{0xe1, 0xe1}, OldSize: 8, PatchHash: []uint8{0xb1, 0xb1}, PatchSize: 5, Ordinal: 1},
+		},
+	}, entry)
+	entry, ok = manifest.Entry([]byte{0xc2, 0xc2})
+	if !ok {
+		t.Fatal("entry not found")
+	}
+	assert.Equal(t, uint64(256), entry.Size)
+	assert.Equal(t, 2, len(entry.Patches))
+	assert.Equal(t, []uint8{0xb3, 0xb3}, entry.Patches[1].PatchHash)
+
+	if _, err := ParsePatchManifest(bytes.NewReader(testPatchManifest(true))); err == nil {
+		t.Fatal("expected invalid checksum error")
+	}
+}
+
+func TestParsePatchConfig(t *testing.T) {
+	cfg, err := ParsePatchConfig(strings.NewReader(`# Patch Configuration
+
+patch = 0123456789abcdef0123456789abcdef
+patch-size = 1234
+patch-entry = encoding c1c1 100 e1e1 50 b:{*=z} e0e0 90 b1b1 20
+patch-entry = install c2c2 10 e2e2 5 n
+`))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	assert.Equal(t, uint64(1234), cfg.PatchSize)
+	assert.Equal(t, []PatchConfigEntry{
+		{
+			Type: "encoding", ContentHash: []byte{0xc1, 0xc1}, ContentSize: 100, EncodedHash: []byte{0xe1, 0xe1}, EncodedSize: 50, ESpec: "b:{*=z}",
+			Patches: []PatchRecord{{OldEncodedHash: []byte{0xe0, 0xe0}, OldSize: 90, PatchHash: []byte{0xb1, 0xb1}, PatchSize: 20}},
+		},
+		{Type: "install", ContentHash: []byte{0xc2, 0xc2}, ContentSize: 10, EncodedHash: []byte{0xe2, 0xe2}, EncodedSize: 5, ESpec: "n"},
+	}, cfg.Entries)
+
+	if _, err := ParsePatchConfig(strings.NewReader("patch-entry = install c2c2 10 e2e2 5 n e0e0\n")); err == nil {
+		t.Fatal("expected invalid patch config error")
+	}
+}
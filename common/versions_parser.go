@@ -21,6 +21,7 @@ type Version struct {
 	Name            string // i.e. A.B.C.XXXXX
 
 	ProductCode string // Optional
+	Active      bool   // Optional, only set by ParseLocalBuildInfo
 }
 
 // ParseLocalBuildInfo parses the .build.info file
@@ -53,6 +54,7 @@ func ParseLocalBuildInfo(r io.Reader) ([]Version, error) {
 			CDNConfigHash:   cdnConfigHash,
 			Name:            row["Version"],
 			ProductCode:     product,
+			Active:          row["Active"] == "1",
 		})
 	}
 	return versions, nil
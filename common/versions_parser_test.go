@@ -22,8 +22,8 @@ eu|1|733e8f4a3e8e0feaa44d52b458592651|d0427daa9162695282f0daeffb46b1d1|||||||||1
 eu|1|b5789e1d3f34ffb8a19b9273166d55c0|d0427daa9162695282f0daeffb46b1d1|||||||||1.32.7.15539|w3t
 `)
 	expected := []Version{
-		{Region: "eu", BuildConfigHash: must(hex.DecodeString("733e8f4a3e8e0feaa44d52b458592651")), CDNConfigHash: must(hex.DecodeString("d0427daa9162695282f0daeffb46b1d1")), Name: "1.32.6.15355", ProductCode: "w3"},
-		{Region: "eu", BuildConfigHash: must(hex.DecodeString("b5789e1d3f34ffb8a19b9273166d55c0")), CDNConfigHash: must(hex.DecodeString("d0427daa9162695282f0daeffb46b1d1")), Name: "1.32.7.15539", ProductCode: "w3t"},
+		{Region: "eu", BuildConfigHash: must(hex.DecodeString("733e8f4a3e8e0feaa44d52b458592651")), CDNConfigHash: must(hex.DecodeString("d0427daa9162695282f0daeffb46b1d1")), Name: "1.32.6.15355", ProductCode: "w3", Active: true},
+		{Region: "eu", BuildConfigHash: must(hex.DecodeString("b5789e1d3f34ffb8a19b9273166d55c0")), CDNConfigHash: must(hex.DecodeString("d0427daa9162695282f0daeffb46b1d1")), Name: "1.32.7.15539", ProductCode: "w3t", Active: true},
 	}
 	actual, err := ParseLocalBuildInfo(data)
 	if err != nil {
@@ -44,7 +44,7 @@ eu|1|17992473d8a335eb5a7fed6699462db8|852ac94d909ed7dcf2d3b76a0e85b16a|||||||||2
 `)
 
 	expected := []Version{
-		{Region: "eu", BuildConfigHash: must(hex.DecodeString("17992473d8a335eb5a7fed6699462db8")), CDNConfigHash: must(hex.DecodeString("852ac94d909ed7dcf2d3b76a0e85b16a")), Name: "2.6.9.68722"},
+		{Region: "eu", BuildConfigHash: must(hex.DecodeString("17992473d8a335eb5a7fed6699462db8")), CDNConfigHash: must(hex.DecodeString("852ac94d909ed7dcf2d3b76a0e85b16a")), Name: "2.6.9.68722", Active: true},
 	}
 	actual, err := ParseLocalBuildInfo(data)
 	if err != nil {
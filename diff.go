@@ -0,0 +1,62 @@
+package casc
+
+import (
+	"bytes"
+	"sort"
+)
+
+// BuildDiff lists the filenames that differ between two builds.
+type BuildDiff struct {
+	Added    []string `json:"added"`
+	Removed  []string `json:"removed"`
+	Modified []string `json:"modified"`
+}
+
+// Diff compares the files of the builds of oldBuild and newBuild using the content hashes of their root.
+// Any mix of Local, Online and Mirror explorers can be compared.
+// Filenames are sorted.
+func Diff(oldBuild, newBuild *Explorer) (BuildDiff, error) {
+	oldHashes, err := contentHashes(oldBuild)
+	if err != nil {
+		return BuildDiff{}, err
+	}
+	newHashes, err := contentHashes(newBuild)
+	if err != nil {
+		return BuildDiff{}, err
+	}
+	diff := BuildDiff{Added: []string{}, Removed: []string{}, Modified: []string{}}
+	for filename, newHash := range newHashes {
+		oldHash, ok := oldHashes[filename]
+		if !ok {
+			diff.Added = append(diff.Added, filename)
+		} else if !bytes.Equal(oldHash, newHash) {
+			diff.Modified = append(diff.Modified, filename)
+		}
+	}
+	for filename := range oldHashes {
+		if _, ok := newHashes[filename]; !ok {
+			diff.Removed = append(diff.Removed, filename)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Modified)
+	return diff, nil
+}
+
+// contentHashes returns the content hash of every file of e.
+func contentHashes(e *Explorer) (map[string][]byte, error) {
+	filenames, err := e.Files()
+	if err != nil {
+		return nil, err
+	}
+	hashes := make(map[string][]byte, len(filenames))
+	for _, filename := range filenames {
+		hash, err := e.root.ContentHash(filename)
+		if err != nil {
+			return nil, err
+		}
+		hashes[filename] = hash
+	}
+	return hashes, nil
+}
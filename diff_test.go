@@ -0,0 +1,38 @@
+package casc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	oldBuild := testExplorer(map[string]string{
+		"same.txt":     "same",
+		"modified.txt": "old",
+		"removed.txt":  "removed",
+	})
+	newBuild := testExplorer(map[string]string{
+		"same.txt":     "same",
+		"modified.txt": "new",
+		"added.txt":    "added",
+		"added2.txt":   "added",
+	})
+	diff, err := Diff(oldBuild, newBuild)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expected := BuildDiff{
+		Added:    []string{"added.txt", "added2.txt"},
+		Removed:  []string{"removed.txt"},
+		Modified: []string{"modified.txt"},
+	}
+	if !reflect.DeepEqual(expected, diff) {
+		t.Fatalf("expected %+v got %+v", expected, diff)
+	}
+	if diff, err = Diff(oldBuild, oldBuild); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(diff.Added)+len(diff.Removed)+len(diff.Modified) != 0 {
+		t.Fatalf("unexpected diff %+v", diff)
+	}
+}
@@ -1,19 +1,30 @@
 package casc
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"runtime"
+	"sync"
 
+	"github.com/jybp/casc/blte"
+	"github.com/jybp/casc/common"
 	"github.com/jybp/casc/root/diablo3"
 	"github.com/jybp/casc/root/starcraft1"
 	"github.com/jybp/casc/root/warcraft3"
+	"github.com/jybp/casc/root/wow"
 	"github.com/pkg/errors"
 )
 
 // Program codes
 const (
-	Diablo3    = "d3"
-	Starcraft1 = "s1"
-	Warcraft3  = "w3"
+	Diablo3         = "d3"
+	Starcraft1      = "s1"
+	Warcraft3       = "w3"
+	WorldOfWarcraft = "wow"
 )
 
 // Regions / CDN Regions
@@ -25,30 +36,75 @@ const (
 	RegionCN = "cn"
 )
 
+// productToApps relates the build-product of build configs to program codes.
+var productToApps = map[string]string{
+	"Diablo3":    Diablo3,
+	"StarCraft1": Starcraft1,
+	"War3":       Warcraft3,
+	"WoW":        WorldOfWarcraft,
+}
+
+// productCodeToApps relates the product codes of .build.info files to program codes.
+// Public test realms have their own product code.
+var productCodeToApps = map[string]string{
+	"d3":              Diablo3,
+	"d3t":             Diablo3,
+	"s1":              Starcraft1,
+	"s1t":             Starcraft1,
+	"w3":              Warcraft3,
+	"w3t":             Warcraft3,
+	"wow":             WorldOfWarcraft,
+	"wowt":            WorldOfWarcraft,
+	"wow_classic":     WorldOfWarcraft,
+	"wow_classic_era": WorldOfWarcraft,
+}
+
 // ErrNotFound is the error returned by Explorer.Extract if the file was not found within the CASC file system.
 // For example, it can occur when extracting a file from a locale not installed.
 // This error can be silently ignored by consumers of the casc package.
+// It may be wrapped, i.e. when the CDN answers 404, and must be checked with errors.Is.
 var ErrNotFound = errors.New("file not found")
 
 // storage descibes how to fetch CASC content.
+// Implementations must be safe for concurrent use.
 type storage interface {
 	App() string
 	Version() string
 	RootHash() []byte
+	// InstallHash returns the content hash of the install manifest or nil if there is none.
+	InstallHash() []byte
+	// DownloadHash returns the content hash of the download manifest or nil if there is none.
+	DownloadHash() []byte
+	// PatchHash returns the hash of the patch manifest or nil if there is none.
+	PatchHash() []byte
+	// Patch returns the patch file named after hash.
+	// Returns casc.ErrNotFound if the patch does not exist.
+	Patch(hash []byte) ([]byte, error)
+	// PatchConfig returns the patch config or nil if there is none.
+	PatchConfig() (*common.PatchConfig, error)
 	FromContentHash(hash []byte) ([]byte, error)
+	OpenContentHash(hash []byte) (io.ReadCloser, error)
+	OpenEncodedHash(hash []byte) (io.ReadCloser, error)
+	// ReadContentHashAt reads len(p) bytes of the content starting at off
+	// and only reads the BLTE chunks covering that range.
+	ReadContentHashAt(hash, p []byte, off int64) (int, error)
+	// Stat describes the content hash without reading it. Name and Tags are not set.
+	Stat(hash []byte) (FileInfo, error)
 }
 
 // Each app has its own way of relating file names to content hash.
+// Implementations must be safe for concurrent use.
 type root interface {
 	Files() ([]string, error)
 	ContentHash(filename string) ([]byte, error)
 }
 
 // Explorer allows to list and extract CASC files.
+// An Explorer is safe for concurrent use by multiple goroutines.
 type Explorer struct {
 	storage storage
 	root    root
-	//TODO all methods must be goroutine safe
+	tags    *tagIndex
 }
 
 // Online will use client to fetch CASC files.
@@ -56,27 +112,38 @@ type Explorer struct {
 // region is the region of the game.
 // cdnRegion is the region used to download the files.
 // client is used to perform downloads.
-func Online(app, region, cdnRegion string, client *http.Client) (*Explorer, error) {
-	storage, err := newOnlineStorage(app, region, cdnRegion, client)
+func Online(app, region, cdnRegion string, client *http.Client, opts ...Option) (*Explorer, error) {
+	o := newOptions(opts)
+	storage, err := newOnlineStorage(app, region, cdnRegion, client, o)
 	if err != nil {
 		return nil, err
 	}
-	return newExplorer(storage)
+	return newExplorer(storage, o)
 }
 
 // Local will use files located under installDir to fetch CASC files.
+// The first .build.info entry matching the product code of the app is used unless
+// WithProduct, WithBranch or WithActive are provided. See LocalVersions.
+// The app is detected from the .build.info file and the build config; the name of
+// installDir does not matter. Use WithApp to override the detected app.
 // Examples:
 //  C:\Program Files\Warcraft III
 //  /Applications/Warcraft III
-func Local(installDir string) (*Explorer, error) {
-	local, err := newLocalStorage(installDir)
+func Local(installDir string, opts ...Option) (*Explorer, error) {
+	o := newOptions(opts)
+	local, err := newLocalStorage(installDir, o)
 	if err != nil {
 		return nil, err
 	}
-	return newExplorer(local)
+	return newExplorer(local, o)
 }
 
-func newExplorer(storage storage) (*Explorer, error) {
+// newExplorer parses the root of storage.
+// The files are resolved to the variants of the locale and platform options.
+func newExplorer(storage storage, opts options) (*Explorer, error) {
+	if opts.locale != "" && !common.IsLocale(opts.locale) {
+		return nil, errors.WithStack(fmt.Errorf("unknown locale %s", opts.locale))
+	}
 	rootB, err := storage.FromContentHash(storage.RootHash())
 	if err != nil {
 		return nil, err
@@ -85,15 +152,25 @@ func newExplorer(storage storage) (*Explorer, error) {
 	var errRoot error
 	switch storage.App() {
 	case Diablo3:
-		root, errRoot = diablo3.NewRoot(rootB, storage.FromContentHash)
+		root, errRoot = diablo3.NewLocalizedRoot(rootB, storage.FromContentHash, opts.locale, opts.platform)
 	case Warcraft3:
 		root, errRoot = warcraft3.NewRoot(rootB)
+	case WorldOfWarcraft:
+		root, errRoot = wow.NewRoot(rootB, opts.locale, opts.listfile)
 	case Starcraft1:
 		root, errRoot = starcraft1.NewRoot(rootB)
 	default:
 		return nil, errors.WithStack(errors.New("unsupported app"))
 	}
-	return &Explorer{storage, root}, errRoot
+	if errRoot != nil {
+		return nil, errRoot
+	}
+	if vr, ok := root.(variantRoot); ok {
+		if root, err = newLocalizedRoot(vr, opts.locale, opts.platform); err != nil {
+			return nil, err
+		}
+	}
+	return &Explorer{storage, root, &tagIndex{}}, nil
 }
 
 // App returns the program code.
@@ -113,11 +190,220 @@ func (e Explorer) Files() ([]string, error) {
 }
 
 // Extract extracts the file with the given filename.
-// Returns casc.NotFound if the file was not found.
+// Returns an error matching casc.ErrNotFound with errors.Is if the file was not found.
 func (e Explorer) Extract(filename string) ([]byte, error) {
+	return readAll(e.Open(filename))
+}
+
+// Open opens the file with the given filename for streaming.
+// The content is decoded while being read and is never fully loaded in memory.
+// The caller must close the returned io.ReadCloser.
+// Returns an error matching casc.ErrNotFound with errors.Is if the file was not found.
+func (e Explorer) Open(filename string) (io.ReadCloser, error) {
 	contentHash, err := e.root.ContentHash(filename)
 	if err != nil {
 		return nil, err
 	}
+	return e.storage.OpenContentHash(contentHash)
+}
+
+// ExtractByContentHash extracts the content with the given content hash (CKey).
+// Content without filename, such as the install and download manifests, can be extracted.
+// Returns an error matching casc.ErrNotFound with errors.Is if the content was not found.
+func (e Explorer) ExtractByContentHash(contentHash []byte) ([]byte, error) {
+	return e.storage.FromContentHash(contentHash)
+}
+
+// ExtractByEncodedKey extracts the content with the given encoded key (EKey).
+// Content not listed by the encoding file, such as the encoding file itself, can be extracted.
+func (e Explorer) ExtractByEncodedKey(encodedKey []byte) ([]byte, error) {
+	return readAll(e.storage.OpenEncodedHash(encodedKey))
+}
+
+// fileDataIDRoot is implemented by roots identifying files by FileDataID.
+type fileDataIDRoot interface {
+	FileDataIDContentHash(id uint32) ([]byte, error)
+}
+
+// ExtractByFileDataID extracts the file with the given FileDataID.
+// Only World of Warcraft identifies files by FileDataID.
+func (e Explorer) ExtractByFileDataID(id uint32) ([]byte, error) {
+	r, ok := e.root.(fileDataIDRoot)
+	if !ok {
+		return nil, errors.WithStack(fmt.Errorf("%s has no FileDataID", e.App()))
+	}
+	contentHash, err := r.FileDataIDContentHash(id)
+	if err != nil {
+		return nil, err
+	}
 	return e.storage.FromContentHash(contentHash)
 }
+
+// Install returns the install manifest which lists the files installed by the launcher.
+func (e Explorer) Install() (*common.Install, error) {
+	installHash := e.storage.InstallHash()
+	if len(installHash) == 0 {
+		return nil, errors.WithStack(errors.New("no install manifest"))
+	}
+	b, err := e.storage.FromContentHash(installHash)
+	if err != nil {
+		return nil, err
+	}
+	return common.ParseInstall(bytes.NewReader(b))
+}
+
+// InstallFiles returns the entries of the install manifest with the given tags (i.e. "Windows", "x86_64" or "enUS").
+// Tags of the same type are alternatives (i.e. "enUS" or "frFR") and tags of different types are all required.
+// All entries are returned if no tags are given.
+func (e Explorer) InstallFiles(tags ...string) ([]common.InstallEntry, error) {
+	install, err := e.Install()
+	if err != nil {
+		return nil, err
+	}
+	return install.Filter(tags...)
+}
+
+// Download returns the download manifest which lists the encoded files downloaded by the launcher.
+// Use Download().Filter to get the entries sorted by priority: files with the lowest
+// priority are required to start playing, the others are downloaded in the background.
+func (e Explorer) Download() (*common.Download, error) {
+	downloadHash := e.storage.DownloadHash()
+	if len(downloadHash) == 0 {
+		return nil, errors.WithStack(errors.New("no download manifest"))
+	}
+	b, err := e.storage.FromContentHash(downloadHash)
+	if err != nil {
+		return nil, err
+	}
+	return common.ParseDownload(bytes.NewReader(b))
+}
+
+// ReadAt returns up to n bytes of the file with the given filename starting at off.
+// Fewer bytes are returned if the file ends before off+n.
+// Only the BLTE chunks covering the range are read, which means that when
+// using Online only the BLTE header and these chunks are downloaded.
+// Returns casc.NotFound if the file was not found.
+func (e Explorer) ReadAt(filename string, off int64, n int) ([]byte, error) {
+	if off < 0 || n < 0 {
+		return nil, errors.WithStack(fmt.Errorf("invalid offset %d or length %d", off, n))
+	}
+	contentHash, err := e.root.ContentHash(filename)
+	if err != nil {
+		return nil, err
+	}
+	p := make([]byte, n)
+	read, err := e.storage.ReadContentHashAt(contentHash, p, off)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return p[:read], nil
+}
+
+// Extracted is the result of the extraction of a file by ExtractMany or ExtractAll.
+type Extracted struct {
+	Filename string
+	Data     []byte
+	Err      error
+}
+
+// ExtractMany extracts filenames using workers goroutines.
+// If workers is zero or less, runtime.NumCPU() goroutines are used.
+// Results are sent on the returned channel as they complete, in no particular order.
+// The channel is closed once all filenames have been extracted or once ctx is done.
+// The caller must receive results until the channel is closed or cancel ctx.
+func (e Explorer) ExtractMany(ctx context.Context, filenames []string, workers int) <-chan Extracted {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	jobs := make(chan string)
+	results := make(chan Extracted)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for filename := range jobs {
+				if ctx.Err() != nil {
+					return
+				}
+				b, err := e.Extract(filename)
+				select {
+				case results <- Extracted{Filename: filename, Data: b, Err: err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		for _, filename := range filenames {
+			select {
+			case jobs <- filename:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// ExtractAll extracts all files using workers goroutines.
+// See ExtractMany.
+func (e Explorer) ExtractAll(ctx context.Context, workers int) (<-chan Extracted, error) {
+	filenames, err := e.Files()
+	if err != nil {
+		return nil, err
+	}
+	return e.ExtractMany(ctx, filenames, workers), nil
+}
+
+// readCloser combines a decoding io.Reader with the io.Closer of the underlying source.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// sectionReadCloser is an io.SectionReader whose underlying data is closed by Close.
+type sectionReadCloser struct {
+	*io.SectionReader
+	io.Closer
+}
+
+// readEncodedAt reads len(p) bytes starting at off of the content decoded from
+// the BLTE data of sr using blteOpts, and closes sr.
+func readEncodedAt(sr *sectionReadCloser, blteOpts []blte.Option, p []byte, off int64) (n int, rerr error) {
+	defer func() {
+		if cerr := sr.Close(); cerr != nil && rerr == nil {
+			rerr = errors.WithStack(cerr)
+		}
+	}()
+	ra, err := blte.NewReaderAt(sr, sr.Size(), blteOpts...)
+	if err != nil {
+		return 0, err
+	}
+	return ra.ReadAt(p, off)
+}
+
+// readAll reads rc until io.EOF and closes it.
+func readAll(rc io.ReadCloser, err error) (b []byte, rerr error) {
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := rc.Close(); cerr != nil && rerr == nil {
+			rerr = errors.WithStack(cerr)
+		}
+	}()
+	b, err = ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return b, nil
+}
@@ -2,8 +2,15 @@ package casc
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rc4"
+	"encoding/binary"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -13,6 +20,9 @@ import (
 
 	"strings"
 	"testing"
+
+	"github.com/jybp/casc/blte"
+	"github.com/jybp/casc/common"
 )
 
 var slow = flag.Bool("slow", false, "run slow tests")
@@ -209,3 +219,323 @@ func testTempDir(t *testing.T) (string, func()) {
 		}
 	}
 }
+
+// testStorage is an in-memory storage where content hashes are the MD5 of the content.
+// Encoded hashes are the same as content hashes.
+// If encoded is set, contents are BLTE data decoded using blteOpts when opened
+// and opened counts the readers not closed yet.
+type testStorage struct {
+	contents    map[[0x10]byte][]byte
+	patchHash   []byte
+	patches     map[[0x10]byte][]byte
+	patchConfig *common.PatchConfig
+	encoded     bool
+	blteOpts    []blte.Option
+	opened      int
+}
+
+// testReadCloser decrements the readers opened from storage when closed.
+type testReadCloser struct {
+	io.Reader
+	storage *testStorage
+	closed  bool
+}
+
+func (r *testReadCloser) Close() error {
+	if !r.closed {
+		r.closed = true
+		r.storage.opened--
+	}
+	return nil
+}
+
+func (s *testStorage) App() string      { return "test" }
+func (s *testStorage) Version() string  { return "1.0.0.0" }
+func (s *testStorage) RootHash() []byte { return nil }
+
+func (s *testStorage) InstallHash() []byte { return nil }
+
+func (s *testStorage) DownloadHash() []byte { return nil }
+
+func (s *testStorage) PatchHash() []byte { return s.patchHash }
+
+func (s *testStorage) Patch(hash []byte) ([]byte, error) {
+	var key [0x10]byte
+	copy(key[:], hash)
+	b, ok := s.patches[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return b, nil
+}
+
+func (s *testStorage) PatchConfig() (*common.PatchConfig, error) {
+	return s.patchConfig, nil
+}
+
+func (s *testStorage) FromContentHash(hash []byte) ([]byte, error) {
+	return readAll(s.OpenContentHash(hash))
+}
+
+func (s *testStorage) OpenContentHash(hash []byte) (io.ReadCloser, error) {
+	var key [0x10]byte
+	copy(key[:], hash)
+	b, ok := s.contents[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if !s.encoded {
+		return ioutil.NopCloser(bytes.NewReader(b)), nil
+	}
+	r, err := blte.NewReader(bytes.NewReader(b), s.blteOpts...)
+	if err != nil {
+		return nil, err
+	}
+	s.opened++
+	return &testReadCloser{Reader: r, storage: s}, nil
+}
+
+func (s *testStorage) OpenEncodedHash(hash []byte) (io.ReadCloser, error) {
+	return s.OpenContentHash(hash)
+}
+
+func (s *testStorage) ReadContentHashAt(hash, p []byte, off int64) (int, error) {
+	var key [0x10]byte
+	copy(key[:], hash)
+	b, ok := s.contents[key]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return bytes.NewReader(b).ReadAt(p, off)
+}
+
+func (s *testStorage) Stat(hash []byte) (FileInfo, error) {
+	var key [0x10]byte
+	copy(key[:], hash)
+	b, ok := s.contents[key]
+	if !ok {
+		return FileInfo{}, ErrNotFound
+	}
+	return FileInfo{ContentHash: hash, Size: uint64(len(b)), EncodedKeys: [][]byte{hash}}, nil
+}
+
+// testRoot relates filenames to content hashes.
+type testRoot struct {
+	nameToContentHash map[string][]byte
+}
+
+func (r *testRoot) Files() ([]string, error) {
+	names := []string{}
+	for name := range r.nameToContentHash {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (r *testRoot) ContentHash(filename string) ([]byte, error) {
+	hash, ok := r.nameToContentHash[filename]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return hash, nil
+}
+
+// testExplorer returns an Explorer serving files from memory.
+func testExplorer(files map[string]string) *Explorer {
+	storage := &testStorage{contents: map[[0x10]byte][]byte{}}
+	root := &testRoot{nameToContentHash: map[string][]byte{}}
+	for name, content := range files {
+		hash := md5.Sum([]byte(content))
+		storage.contents[hash] = []byte(content)
+		root.nameToContentHash[name] = hash[:]
+	}
+	return &Explorer{storage, root, &tagIndex{}}
+}
+
+func TestExtractByHash(t *testing.T) {
+	explorer := testExplorer(map[string]string{"file": "content"})
+	hash := md5.Sum([]byte("content"))
+	for name, extract := range map[string]func([]byte) ([]byte, error){
+		"content hash": explorer.ExtractByContentHash,
+		"encoded key":  explorer.ExtractByEncodedKey,
+	} {
+		b, err := extract(hash[:])
+		if err != nil {
+			t.Fatalf("%s: %+v", name, err)
+		}
+		if string(b) != "content" {
+			t.Fatalf("%s: unexpected content %q", name, b)
+		}
+		missing := md5.Sum([]byte("missing"))
+		if _, err := extract(missing[:]); err != ErrNotFound {
+			t.Fatalf("%s: expected ErrNotFound got %+v", name, err)
+		}
+	}
+}
+
+func TestReadAtInvalid(t *testing.T) {
+	explorer := testExplorer(map[string]string{"file": "content"})
+	if _, err := explorer.ReadAt("file", 0, -1); err == nil {
+		t.Fatal("expected an error for a negative length")
+	}
+	if _, err := explorer.ReadAt("file", -1, 1); err == nil {
+		t.Fatal("expected an error for a negative offset")
+	}
+}
+
+func TestExtractMany(t *testing.T) {
+	files := map[string]string{}
+	for i := 0; i < 100; i++ {
+		files[fmt.Sprintf("dir/file%03d", i)] = fmt.Sprintf("content %d", i)
+	}
+	explorer := testExplorer(files)
+	for _, workers := range []int{0, 1, 7} {
+		results, err := explorer.ExtractAll(context.Background(), workers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		count := 0
+		for result := range results {
+			count++
+			if result.Err != nil {
+				t.Fatalf("%s: %+v", result.Filename, result.Err)
+			}
+			if string(result.Data) != files[result.Filename] {
+				t.Fatalf("%s: expected %q got %q", result.Filename, files[result.Filename], result.Data)
+			}
+		}
+		if count != len(files) {
+			t.Fatalf("expected %d results got %d", len(files), count)
+		}
+	}
+	for result := range explorer.ExtractMany(context.Background(), []string{"missing"}, 2) {
+		if result.Err != ErrNotFound {
+			t.Fatalf("expected ErrNotFound got %v", result.Err)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1000)
+	var encoded bytes.Buffer
+	w, err := blte.NewWriter(&encoded, "b:{1K*=n}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(content)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	hash := md5.Sum(encoded.Bytes())
+	storage := &testStorage{contents: map[[0x10]byte][]byte{hash: encoded.Bytes()}, encoded: true}
+	root := &testRoot{nameToContentHash: map[string][]byte{"file": hash[:]}}
+	explorer := &Explorer{storage, root, &tagIndex{}}
+
+	extracted, err := explorer.Extract("file")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !bytes.Equal(extracted, content) {
+		t.Fatal("unexpected extracted content")
+	}
+
+	f, err := explorer.Open("file")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if storage.opened != 1 {
+		t.Fatalf("unexpected opened readers %d", storage.opened)
+	}
+	var streamed []byte
+	p := make([]byte, 700)
+	for {
+		n, err := f.Read(p)
+		streamed = append(streamed, p[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	if !bytes.Equal(streamed, extracted) {
+		t.Fatal("streamed content differs from extracted content")
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if storage.opened != 0 {
+		t.Fatalf("reader not closed: %d opened", storage.opened)
+	}
+
+	if _, err := explorer.Open("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound got %v", err)
+	}
+}
+
+var testKey = []byte{0: 0x42, 15: 0x24}
+
+const testKeyName = uint64(0xFA505078126ACB3E)
+
+// encryptedBlte returns a blte file made of a chunk containing "hello" encrypted with testKey using ARC4
+// and of an uncompressed chunk containing ", world".
+func encryptedBlte() []byte {
+	iv := []byte{1, 2, 3, 4}
+	plain := []byte("Nhello")
+	encrypted := make([]byte, len(plain))
+	cipher, _ := rc4.NewCipher(append(append([]byte{}, testKey...), iv...))
+	cipher.XORKeyStream(encrypted, plain)
+	name := make([]byte, 8)
+	binary.LittleEndian.PutUint64(name, testKeyName)
+	chunkE := bytes.Join([][]byte{{'E', 8}, name, {4}, iv, {'A'}, encrypted}, nil)
+	chunkN := []byte("N, world")
+	hashE := md5.Sum(chunkE)
+	hashN := md5.Sum(chunkN)
+	return bytes.Join([][]byte{
+		{66, 76, 84, 69, 0, 0, 0, 12 + 24*2, 0, 0, 0, 2},
+		{0, 0, 0, byte(len(chunkE)), 0, 0, 0, 5}, hashE[:],
+		{0, 0, 0, byte(len(chunkN)), 0, 0, 0, 7}, hashN[:],
+		chunkE,
+		chunkN,
+	}, nil)
+}
+
+func TestExtractEncrypted(t *testing.T) {
+	encoded := encryptedBlte()
+	hash := md5.Sum(encoded)
+	explorer := func(opts ...Option) *Explorer {
+		storage := &testStorage{
+			contents: map[[0x10]byte][]byte{hash: encoded},
+			encoded:  true,
+			blteOpts: newOptions(opts).blteOptions(),
+		}
+		root := &testRoot{nameToContentHash: map[string][]byte{"file": hash[:]}}
+		return &Explorer{storage, root, &tagIndex{}}
+	}
+
+	b, err := explorer(WithKeys(blte.KeyRing{testKeyName: testKey})).Extract("file")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if string(b) != "hello, world" {
+		t.Fatalf("unexpected %q", b)
+	}
+
+	b, err = explorer(WithZeroFill()).Extract("file")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if string(b) != "\x00\x00\x00\x00\x00, world" {
+		t.Fatalf("unexpected %q", b)
+	}
+
+	_, err = explorer().Extract("file")
+	var missingKey *blte.MissingKeyError
+	if !errors.As(err, &missingKey) {
+		t.Fatalf("expected missing key error got %+v", err)
+	}
+	if missingKey.Name != testKeyName {
+		t.Fatalf("unexpected key name %X", missingKey.Name)
+	}
+}
@@ -0,0 +1,302 @@
+package casc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/jybp/casc/common"
+	"github.com/pkg/errors"
+)
+
+// FS exposes the files of an Explorer as a read-only hierarchical file system.
+// Directories are synthesized from the '/' separators within the filenames.
+// Filenames that are not valid fs paths (see fs.ValidPath) are not exposed.
+// A filename that is also the directory of other filenames, such as "a" with "a/b",
+// is exposed as a directory: the file is listed by Shadowed and remains reachable with Explorer.Open.
+// FS implements fs.FS, fs.ReadDirFS and fs.StatFS.
+type FS struct {
+	explorer Explorer
+	files    map[string]struct{}
+	dirs     map[string][]fs.DirEntry
+	shadowed []string
+}
+
+var (
+	_ fs.FS        = (*FS)(nil)
+	_ fs.ReadDirFS = (*FS)(nil)
+	_ fs.StatFS    = (*FS)(nil)
+)
+
+// FS returns a file system exposing all files of the Explorer.
+func (e Explorer) FS() (*FS, error) {
+	filenames, err := e.Files()
+	if err != nil {
+		return nil, err
+	}
+	fsys := &FS{
+		explorer: e,
+		files:    map[string]struct{}{},
+		dirs:     map[string][]fs.DirEntry{".": nil},
+	}
+	for _, filename := range filenames {
+		if !fs.ValidPath(filename) || filename == "." {
+			continue
+		}
+		fsys.files[filename] = struct{}{}
+	}
+	for filename := range fsys.files {
+		for dir := path.Dir(filename); dir != "."; dir = path.Dir(dir) {
+			if _, ok := fsys.files[dir]; ok {
+				// A file cannot also be a directory.
+				delete(fsys.files, dir)
+				fsys.shadowed = append(fsys.shadowed, dir)
+			}
+		}
+	}
+	sort.Strings(fsys.shadowed)
+	for _, filename := range fsys.shadowed {
+		fmt.Fprintf(common.Wlog, "file %s shadowed by a directory\n", filename)
+	}
+	for filename := range fsys.files {
+		fsys.addEntry(filename, false)
+	}
+	for _, entries := range fsys.dirs {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	}
+	return fsys, nil
+}
+
+// Shadowed returns the sorted filenames that are not exposed because they are also directories.
+func (fsys *FS) Shadowed() []string {
+	return append([]string{}, fsys.shadowed...)
+}
+
+// addEntry adds name to its parent directory, creating the parent directories as needed.
+func (fsys *FS) addEntry(name string, isDir bool) {
+	if isDir {
+		if _, ok := fsys.dirs[name]; ok {
+			return
+		}
+		fsys.dirs[name] = nil
+	}
+	parent := path.Dir(name)
+	if parent != "." {
+		fsys.addEntry(parent, true)
+	}
+	entry := &dirEntry{fsys: fsys, name: name, isDir: isDir}
+	fsys.dirs[parent] = append(fsys.dirs[parent], entry)
+}
+
+// Open opens the named file or directory.
+// The content of a file is streamed from the underlying storage.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if entries, ok := fsys.dirs[name]; ok {
+		return &dir{info: dirInfo(name), entries: entries}, nil
+	}
+	if _, ok := fsys.files[name]; !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	rc, err := fsys.explorer.Open(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &file{explorer: fsys.explorer, name: name, rc: rc}, nil
+}
+
+// ReadDir reads the named directory and returns its entries sorted by filename.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	entries, ok := fsys.dirs[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]fs.DirEntry{}, entries...), nil
+}
+
+// Stat returns a fs.FileInfo describing the named file or directory.
+// The content of a file is not read. Sys returns the casc.FileInfo of a file.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	if _, ok := fsys.dirs[name]; ok {
+		return dirInfo(name), nil
+	}
+	if _, ok := fsys.files[name]; !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return statFile(fsys.explorer, name)
+}
+
+// statFile returns the fileInfo of the file name of explorer.
+func statFile(explorer Explorer, name string) (fs.FileInfo, error) {
+	info, err := explorer.Stat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return fileInfo{name: path.Base(name), size: int64(info.Size), sys: info}, nil
+}
+
+// dirEntry is a fs.DirEntry of a FS.
+type dirEntry struct {
+	fsys  *FS
+	name  string
+	isDir bool
+}
+
+func (d *dirEntry) Name() string { return path.Base(d.name) }
+
+func (d *dirEntry) IsDir() bool { return d.isDir }
+
+func (d *dirEntry) Type() fs.FileMode {
+	if d.isDir {
+		return fs.ModeDir
+	}
+	return 0
+}
+
+func (d *dirEntry) Info() (fs.FileInfo, error) {
+	return d.fsys.Stat(d.name)
+}
+
+// fileInfo is a fs.FileInfo of a FS.
+// CASC files carry no modification time nor permissions.
+type fileInfo struct {
+	name  string
+	size  int64
+	isDir bool
+	sys   interface{}
+}
+
+func dirInfo(name string) fileInfo {
+	return fileInfo{name: path.Base(name), isDir: true}
+}
+
+func (i fileInfo) Name() string { return i.name }
+
+func (i fileInfo) Size() int64 { return i.size }
+
+func (i fileInfo) Mode() fs.FileMode {
+	if i.isDir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (i fileInfo) ModTime() time.Time { return time.Time{} }
+
+func (i fileInfo) IsDir() bool { return i.isDir }
+
+func (i fileInfo) Sys() interface{} { return i.sys }
+
+// dir is an opened directory of a FS.
+type dir struct {
+	info    fileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return d.info, nil }
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+func (d *dir) Close() error { return nil }
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return append([]fs.DirEntry{}, remaining...), nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+	d.offset += n
+	return append([]fs.DirEntry{}, remaining[:n]...), nil
+}
+
+// file is an opened file of a FS.
+// Its content is streamed until Seek requires the whole content to be extracted.
+type file struct {
+	explorer Explorer
+	name     string
+	rc       io.ReadCloser
+	pos      int64
+
+	content *bytes.Reader
+	closed  bool
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if f.content != nil {
+		return f.content.Read(p)
+	}
+	n, err := f.rc.Read(p)
+	f.pos += int64(n)
+	return n, err
+}
+
+// load extracts the whole content and keeps the current read position.
+func (f *file) load() error {
+	if f.content != nil {
+		return nil
+	}
+	b, err := f.explorer.Extract(f.name)
+	if err != nil {
+		return err
+	}
+	if err := f.rc.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	f.content = bytes.NewReader(b)
+	if _, err := f.content.Seek(f.pos, io.SeekStart); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if err := f.load(); err != nil {
+		return 0, err
+	}
+	return f.content.Seek(offset, whence)
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	if f.closed {
+		return nil, fs.ErrClosed
+	}
+	return statFile(f.explorer, f.name)
+}
+
+func (f *file) Close() error {
+	if f.closed {
+		return fs.ErrClosed
+	}
+	f.closed = true
+	if f.content != nil {
+		return nil
+	}
+	return f.rc.Close()
+}
@@ -0,0 +1,71 @@
+package casc
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFS(t *testing.T) {
+	explorer := testExplorer(map[string]string{
+		"CustomKeyInfo.txt":                   "keys",
+		"War3.mpq:Units/Human/Footman.blp":    "footman",
+		"War3.mpq:Units/Human/Knight.blp":     "knight",
+		"War3.mpq:Units/Orc/Grunt.blp":        "grunt",
+		"enUS-War3Local.mpq:Movies/Intro.mp4": "intro",
+		"enUS-War3Local.mpq:Movies/Outro.mp4": "",
+		"invalid//name":                       "skipped",
+	})
+	fsys, err := explorer.FS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(fsys,
+		"CustomKeyInfo.txt",
+		"War3.mpq:Units/Human/Footman.blp",
+		"War3.mpq:Units/Human/Knight.blp",
+		"War3.mpq:Units/Orc/Grunt.blp",
+		"enUS-War3Local.mpq:Movies/Intro.mp4",
+		"enUS-War3Local.mpq:Movies/Outro.mp4",
+	); err != nil {
+		t.Fatal(err)
+	}
+	matches, err := fs.Glob(fsys, "War3.mpq:Units/*/*.blp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 3 {
+		t.Fatalf("expected 3 matches got %v", matches)
+	}
+	if _, err := fs.Stat(fsys, "invalid//name"); err == nil {
+		t.Fatal("expected invalid name to be skipped")
+	}
+}
+
+func TestFSShadowed(t *testing.T) {
+	explorer := testExplorer(map[string]string{
+		"a":   "file",
+		"a/b": "b",
+		"a/c": "c",
+	})
+	fsys, err := explorer.FS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(fsys, "a/b", "a/c"); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := fs.Stat(fsys, "a"); err != nil || !info.IsDir() {
+		t.Fatalf("expected a to be a directory got %v, %v", info, err)
+	}
+	if shadowed := fsys.Shadowed(); len(shadowed) != 1 || shadowed[0] != "a" {
+		t.Fatalf("unexpected shadowed files %v", shadowed)
+	}
+	b, err := explorer.Extract("a")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if string(b) != "file" {
+		t.Fatalf("unexpected content %q", b)
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/jybp/casc
 
-go 1.13
+go 1.16
 
 require (
 	github.com/pkg/errors v0.9.1
@@ -1,6 +1,7 @@
 package casc
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"encoding/hex"
@@ -21,56 +22,43 @@ type local struct {
 	app             string
 	versionName     string
 	rootEncodedHash []byte
+	installHash     []byte
+	downloadHash    []byte
 	dataDir         string
-	encoding        map[string][][]byte
-	idxs            map[uint8][]common.IdxEntry
+	encoding        *common.Encoding
+	idxs            map[uint8]*idxBucket
+	blteOpts        []blte.Option
 }
 
-func newLocalStorage(installDir string) (l *local, err error) {
+func newLocalStorage(installDir string, opts options) (l *local, err error) {
 
 	//
 	// app & versionName
 	//
 
+	// The directory name is only a hint used to select the .build.info entry.
+	// The app is derived from the selected entry and from the build config.
 	var dirToApp = map[string]string{
-		"Diablo III":   Diablo3,
-		"StarCraft":    Starcraft1,
-		"Warcraft III": Warcraft3,
+		"Diablo III":        Diablo3,
+		"StarCraft":         Starcraft1,
+		"Warcraft III":      Warcraft3,
+		"World of Warcraft": WorldOfWarcraft,
 	}
-	app, ok := dirToApp[filepath.Base(installDir)]
-	if !ok {
-		return nil, errors.WithStack(errors.New("unsupported app"))
-	}
-	var cascDir string
-	switch app {
-	case Diablo3, Starcraft1, Warcraft3:
-		cascDir = filepath.Join(installDir, "Data")
-	default:
-		return nil, errors.WithStack(errors.New("unsupported app"))
+	hint := opts.app
+	if hint == "" {
+		hint = dirToApp[filepath.Base(installDir)]
 	}
+	cascDir := filepath.Join(installDir, "Data")
 
-	buildInfoB, err := ioutil.ReadFile(filepath.Join(installDir, ".build.info"))
+	versions, err := LocalVersions(installDir)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, err
 	}
-	versions, err := common.ParseLocalBuildInfo(bytes.NewReader(buildInfoB))
+	version, err := selectVersion(versions, hint, opts)
 	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-	if len(versions) == 0 {
-		return nil, errors.WithStack(errors.New("no entries within .build.info"))
-	}
-
-	var version common.Version = versions[0]
-	for _, v := range versions {
-		if len(v.ProductCode) > 0 && v.ProductCode == app {
-			version = v
-			break
-		}
+		return nil, err
 	}
 
-	fmt.Fprintf(common.Wlog, "app %s, version %s and region %s\n", app, version.Name, version.Region)
-
 	//
 	// rootEncodedHash & app
 	//
@@ -79,6 +67,9 @@ func newLocalStorage(installDir string) (l *local, err error) {
 	dataDir := filepath.Join(cascDir, common.PathTypeData)
 
 	buildConfigHash := hex.EncodeToString(version.BuildConfigHash)
+	if len(buildConfigHash) < 4 {
+		return nil, errors.WithStack(errors.New("invalid build config hash len"))
+	}
 	buildConfigB, err := ioutil.ReadFile(filepath.Join(
 		configDir,
 		buildConfigHash[0:2],
@@ -92,19 +83,13 @@ func newLocalStorage(installDir string) (l *local, err error) {
 		return nil, err
 	}
 	rootHash := buildCfg.RootHash
-	var productToApps = map[string]string{
-		"Diablo3":    Diablo3,
-		"StarCraft1": Starcraft1,
-		"War3":       Warcraft3,
-	}
-	buildApp, ok := productToApps[buildCfg.BuildProduct]
-	if !ok {
-		return nil, errors.WithStack(errors.Errorf("unknown build-product: %s", buildCfg.BuildProduct))
-	}
-	if app != buildApp {
-		return nil, errors.WithStack(errors.Errorf("inconsistent app %s != %s", app, buildApp))
+	app, err := localApp(opts.app, version.ProductCode, buildCfg.BuildProduct)
+	if err != nil {
+		return nil, err
 	}
 
+	fmt.Fprintf(common.Wlog, "app %s, version %s and region %s\n", app, version.Name, version.Region)
+
 	//
 	// encoding & dataFromEncodedHashFn
 	//
@@ -116,9 +101,9 @@ func newLocalStorage(installDir string) (l *local, err error) {
 	}
 	// There is multiple files for the same bucket with duplicate entries.
 	// It looks like the last file contains the most up to date indices.
-	// Sort the files accordingly so that the first index findIdx finds is the correct.
+	// Sort the files accordingly so that the first entry added to a bucket for a key is the correct.
 	sort.Slice(files, func(i, j int) bool { return files[i].Name() > files[j].Name() })
-	idxEntries := map[uint8][]common.IdxEntry{}
+	idxBuckets := map[uint8]*idxBucket{}
 	for _, file := range files {
 		name := file.Name()
 		if len(name) < 4 {
@@ -145,17 +130,24 @@ func newLocalStorage(installDir string) (l *local, err error) {
 		if err != nil {
 			return nil, err
 		}
-		idxEntries[uint8(bucketID)] = append(idxEntries[uint8(bucketID)], indices...)
+		bucket, ok := idxBuckets[uint8(bucketID)]
+		if !ok {
+			bucket = &idxBucket{entries: map[string]common.IdxEntry{}}
+			idxBuckets[uint8(bucketID)] = bucket
+		}
+		if err := bucket.add(indices); err != nil {
+			return nil, err
+		}
 	}
 
 	if len(buildCfg.EncodingHashes) < 2 {
 		return nil, errors.WithStack(errors.New("expected at least two encoding hash"))
 	}
-	encodingR, err := dataFromEncodedHash(buildCfg.EncodingHashes[1], dataDir, idxEntries)
+	encodingB, err := readAll(openEncodedHash(buildCfg.EncodingHashes[1], dataDir, idxBuckets, nil))
 	if err != nil {
 		return nil, err
 	}
-	encoding, err := common.ParseEncoding(bytes.NewReader(encodingR))
+	encoding, err := common.ParseEncoding(bytes.NewReader(encodingB))
 	if err != nil {
 		return nil, err
 	}
@@ -164,12 +156,80 @@ func newLocalStorage(installDir string) (l *local, err error) {
 		app:             app,
 		versionName:     version.Name,
 		rootEncodedHash: rootHash,
+		installHash:     contentHash(buildCfg.InstallHashes),
+		downloadHash:    contentHash(buildCfg.DownloadHashes),
 		encoding:        encoding,
 		dataDir:         dataDir,
-		idxs:            idxEntries,
+		idxs:            idxBuckets,
+		blteOpts:        opts.blteOptions(),
 	}, nil
 }
 
+// localApp returns the program code of a local storage.
+// app overrides the program code derived from the .build.info product code and the build-product.
+// An error is returned if the build-product is known and is not consistent with the program code.
+func localApp(app, productCode, buildProduct string) (string, error) {
+	buildApp, buildAppOk := productToApps[buildProduct]
+	if app == "" {
+		app = productCodeToApps[productCode]
+	}
+	if app == "" {
+		app = buildApp
+	}
+	if app == "" {
+		return "", errors.WithStack(errors.Errorf("unsupported app: product %q, build-product %q", productCode, buildProduct))
+	}
+	if buildAppOk && app != buildApp {
+		return "", errors.WithStack(errors.Errorf("inconsistent app %s != %s", app, buildApp))
+	}
+	return app, nil
+}
+
+// LocalVersions returns all entries of the .build.info file located under installDir.
+// Use WithProduct, WithBranch and WithActive to select the entry used by Local.
+func LocalVersions(installDir string) ([]common.Version, error) {
+	buildInfoB, err := ioutil.ReadFile(filepath.Join(installDir, ".build.info"))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	versions, err := common.ParseLocalBuildInfo(bytes.NewReader(buildInfoB))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(versions) == 0 {
+		return nil, errors.WithStack(errors.New("no entries within .build.info"))
+	}
+	return versions, nil
+}
+
+// selectVersion returns the first version matching the product, branch and active options.
+// Without options, the first version with the product code app is returned,
+// or the first version if none matches.
+func selectVersion(versions []common.Version, app string, opts options) (common.Version, error) {
+	if opts.product == "" && opts.branch == "" && !opts.active {
+		for _, v := range versions {
+			if len(v.ProductCode) > 0 && v.ProductCode == app {
+				return v, nil
+			}
+		}
+		return versions[0], nil
+	}
+	for _, v := range versions {
+		if opts.product != "" && v.ProductCode != opts.product {
+			continue
+		}
+		if opts.branch != "" && v.Region != opts.branch {
+			continue
+		}
+		if opts.active && !v.Active {
+			continue
+		}
+		return v, nil
+	}
+	return common.Version{}, errors.WithStack(errors.Errorf(
+		"no .build.info entry with product %q, branch %q and active %t", opts.product, opts.branch, opts.active))
+}
+
 func (s *local) App() string {
 	return s.app
 }
@@ -182,12 +242,24 @@ func (s *local) RootHash() []byte {
 	return s.rootEncodedHash
 }
 
+func (s *local) InstallHash() []byte {
+	return s.installHash
+}
+
+func (s *local) DownloadHash() []byte {
+	return s.downloadHash
+}
+
 func (s *local) FromContentHash(hash []byte) ([]byte, error) {
-	encodedHashes, ok := s.encoding[hex.EncodeToString(hash)]
+	return readAll(s.OpenContentHash(hash))
+}
+
+func (s *local) OpenContentHash(hash []byte) (io.ReadCloser, error) {
+	encodedHashes, ok := s.encoding.EncodedHashes(hash)
 	if !ok || len(encodedHashes) == 0 {
 		return nil, ErrNotFound
 	}
-	return dataFromEncodedHash(encodedHashes[0], s.dataDir, s.idxs)
+	return openEncodedHash(encodedHashes[0], s.dataDir, s.idxs, s.blteOpts)
 }
 
 func bucketID(hash []byte) (uint8, error) {
@@ -198,37 +270,125 @@ func bucketID(hash []byte) (uint8, error) {
 	return (i & 0xf) ^ (i >> 4), nil
 }
 
-func findIdx(hash []byte, idxs []common.IdxEntry) (common.IdxEntry, error) {
-	foundIdx := common.IdxEntry{}
-	for _, idx := range idxs {
-		keyLen := len(idx.Key)
-		hashLen := len(hash)
-		shift := hashLen - keyLen
-		if shift < 0 {
-			return common.IdxEntry{}, errors.WithStack(errors.New("invalid key/hash len"))
+// idxBucket indexes the .idx entries of a bucket by their truncated encoded key.
+type idxBucket struct {
+	keyLen  int
+	entries map[string]common.IdxEntry
+}
+
+// add adds entries to the bucket.
+// An entry is ignored if an entry with the same key was previously added.
+func (b *idxBucket) add(entries []common.IdxEntry) error {
+	for _, entry := range entries {
+		if b.keyLen == 0 {
+			b.keyLen = len(entry.Key)
+		}
+		if len(entry.Key) != b.keyLen {
+			return errors.WithStack(errors.New("inconsistent idx key len"))
 		}
-		h := hash[:len(hash)-shift]
-		if bytes.Compare(h, idx.Key) == 0 {
-			foundIdx = idx
-			break
+		if _, ok := b.entries[string(entry.Key)]; ok {
+			continue
 		}
+		b.entries[string(entry.Key)] = entry
 	}
-	if foundIdx.Key == nil {
+	return nil
+}
+
+func findIdx(hash []byte, bucket *idxBucket) (common.IdxEntry, error) {
+	if len(hash) < bucket.keyLen {
+		return common.IdxEntry{}, errors.WithStack(errors.New("invalid key/hash len"))
+	}
+	idx, ok := bucket.entries[string(hash[:bucket.keyLen])]
+	if !ok {
 		return common.IdxEntry{}, ErrNotFound
 	}
-	return foundIdx, nil
+	return idx, nil
 }
 
-func dataFromEncodedHash(hash []byte, dataDir string, idxs map[uint8][]common.IdxEntry) (b []byte, err error) {
+// localIdx returns the .idx entry locating hash within the data.XXX files.
+func localIdx(hash []byte, idxs map[uint8]*idxBucket) (common.IdxEntry, error) {
 	bucketID, err := bucketID(hash)
 	if err != nil {
-		return nil, err
+		return common.IdxEntry{}, err
 	}
-	indices, ok := idxs[bucketID]
+	bucket, ok := idxs[bucketID]
 	if !ok {
-		return nil, errors.WithStack(fmt.Errorf("bucket %x not found", bucketID))
+		// No .idx file of the bucket, i.e. nothing of the bucket is installed.
+		return common.IdxEntry{}, ErrNotFound
+	}
+	return findIdx(hash, bucket)
+}
+
+func (s *local) ReadContentHashAt(hash, p []byte, off int64) (int, error) {
+	encodedHashes, ok := s.encoding.EncodedHashes(hash)
+	if !ok || len(encodedHashes) == 0 {
+		return 0, ErrNotFound
+	}
+	data, err := openEncodedData(encodedHashes[0], s.dataDir, s.idxs)
+	if err != nil {
+		return 0, err
+	}
+	return readEncodedAt(data, s.blteOpts, p, off)
+}
+
+func (s *local) Stat(hash []byte) (FileInfo, error) {
+	info, err := encodingFileInfo(s.encoding, hash)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	idx, err := localIdx(info.EncodedKeys[0], s.idxs)
+	if errors.Is(err, ErrNotFound) {
+		// The content is not installed, i.e. a locale that was not downloaded.
+		return info, nil
+	}
+	if err != nil {
+		return FileInfo{}, err
+	}
+	// The BLTE data follows the 30 bytes header of the data.XXX entry.
+	info.Archive = fmt.Sprintf("data.%03d", idx.Index)
+	info.ArchiveOffset = int64(idx.Offset) + 30
+	if info.EncodedSize == 0 && idx.Size > 30 {
+		info.EncodedSize = uint64(idx.Size) - 30
 	}
-	idx, err := findIdx(hash, indices)
+	return info, nil
+}
+
+func (s *local) OpenEncodedHash(hash []byte) (io.ReadCloser, error) {
+	return openEncodedHash(hash, s.dataDir, s.idxs, s.blteOpts)
+}
+
+func (s *local) PatchHash() []byte {
+	return nil
+}
+
+func (s *local) Patch(hash []byte) ([]byte, error) {
+	return nil, errors.WithStack(errors.New("patches are only available from the CDN"))
+}
+
+func (s *local) PatchConfig() (*common.PatchConfig, error) {
+	return nil, nil
+}
+
+// openEncodedHash opens the data.XXX file containing hash and returns a reader
+// decoding its BLTE content using blteOpts. The data.XXX file is closed with the returned io.ReadCloser.
+func openEncodedHash(hash []byte, dataDir string, idxs map[uint8]*idxBucket, blteOpts []blte.Option) (io.ReadCloser, error) {
+	data, err := openEncodedData(hash, dataDir, idxs)
+	if err != nil {
+		return nil, err
+	}
+	blteReader, err := blte.NewReader(bufio.NewReader(data), blteOpts...)
+	if err != nil {
+		// The decoding error is more relevant than a close error.
+		data.Close()
+		return nil, errors.WithStack(err)
+	}
+	return &readCloser{blteReader, data}, nil
+}
+
+// openEncodedData opens the data.XXX file containing hash and returns the section containing its BLTE data.
+// The data.XXX file is closed with the returned sectionReadCloser.
+func openEncodedData(hash []byte, dataDir string, idxs map[uint8]*idxBucket) (sr *sectionReadCloser, err error) {
+	idx, err := localIdx(hash, idxs)
 	if err != nil {
 		return nil, err
 	}
@@ -238,6 +398,9 @@ func dataFromEncodedHash(hash []byte, dataDir string, idxs map[uint8][]common.Id
 		return nil, errors.WithStack(err)
 	}
 	defer func() {
+		if err == nil {
+			return
+		}
 		if cerr := f.Close(); cerr != nil {
 			err = cerr
 		}
@@ -264,16 +427,6 @@ func dataFromEncodedHash(hash []byte, dataDir string, idxs map[uint8][]common.Id
 	if size != idx.Size {
 		return nil, errors.WithStack(errors.New("inconsistent size"))
 	}
-	if _, err := f.Seek(10, io.SeekCurrent); err != nil { //unk, ChecksumA, ChecksumB
-		return nil, errors.WithStack(err)
-	}
-	blteReader, err := blte.NewReader(io.LimitReader(f, int64(idx.Size-30)))
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-	b, err = ioutil.ReadAll(blteReader)
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-	return b, nil
+	// The BLTE data follows the 30 bytes header (blteHash, size, unk, ChecksumA, ChecksumB).
+	return &sectionReadCloser{io.NewSectionReader(f, int64(idx.Offset)+30, int64(idx.Size)-30), f}, nil
 }
@@ -0,0 +1,330 @@
+package casc
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jybp/casc/common"
+)
+
+func TestFindIdx(t *testing.T) {
+	bucket := &idxBucket{entries: map[string]common.IdxEntry{}}
+	newest := []common.IdxEntry{
+		{Key: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9}, Index: 2, Offset: 10, Size: 100},
+	}
+	oldest := []common.IdxEntry{
+		{Key: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9}, Index: 1, Offset: 20, Size: 200},
+		{Key: []byte{9, 8, 7, 6, 5, 4, 3, 2, 1}, Index: 1, Offset: 30, Size: 300},
+	}
+	if err := bucket.add(newest); err != nil {
+		t.Fatal(err)
+	}
+	if err := bucket.add(oldest); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := findIdx([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}, bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Index != 2 || idx.Offset != 10 {
+		t.Fatalf("expected the newest entry got %+v", idx)
+	}
+	idx, err = findIdx([]byte{9, 8, 7, 6, 5, 4, 3, 2, 1, 0, 0, 0, 0, 0, 0, 0}, bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Offset != 30 {
+		t.Fatalf("unexpected entry %+v", idx)
+	}
+	if _, err := findIdx([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, bucket); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound got %v", err)
+	}
+	if err := bucket.add([]common.IdxEntry{{Key: []byte{1, 2, 3}}}); err == nil {
+		t.Fatal("expected inconsistent key len error")
+	}
+}
+
+func TestSelectVersion(t *testing.T) {
+	versions := []common.Version{
+		{Region: "us", Name: "1", ProductCode: "w3", Active: false},
+		{Region: "eu", Name: "2", ProductCode: "w3t", Active: true},
+		{Region: "eu", Name: "3", ProductCode: "w3", Active: true},
+	}
+	tests := []struct {
+		opts     []Option
+		expected string
+	}{
+		{nil, "1"},
+		{[]Option{WithProduct("w3t")}, "2"},
+		{[]Option{WithBranch("eu")}, "2"},
+		{[]Option{WithBranch("eu"), WithProduct("w3")}, "3"},
+		{[]Option{WithActive(), WithProduct("w3")}, "3"},
+		{[]Option{WithActive()}, "2"},
+	}
+	for _, test := range tests {
+		version, err := selectVersion(versions, Warcraft3, newOptions(test.opts))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version.Name != test.expected {
+			t.Errorf("expected version %s got %s", test.expected, version.Name)
+		}
+	}
+	if _, err := selectVersion(versions, Warcraft3, newOptions([]Option{WithBranch("kr")})); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestLocalApp(t *testing.T) {
+	tests := []struct {
+		app, productCode, buildProduct string
+		expected                       string
+	}{
+		{"", "w3t", "War3", Warcraft3},
+		{"", "", "War3", Warcraft3},
+		{"", "d3", "", Diablo3},
+		{"", "wow_classic", "WoW", WorldOfWarcraft},
+		{Starcraft1, "", "", Starcraft1},
+		{Starcraft1, "unknown", "Unknown", Starcraft1},
+	}
+	for _, test := range tests {
+		app, err := localApp(test.app, test.productCode, test.buildProduct)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if app != test.expected {
+			t.Errorf("expected app %s got %s", test.expected, app)
+		}
+	}
+	if _, err := localApp("", "unknown", "Unknown"); err == nil {
+		t.Error("expected unsupported app error")
+	}
+	if _, err := localApp(Diablo3, "", "War3"); err == nil {
+		t.Error("expected inconsistent app error")
+	}
+}
+
+// newTestLocal writes a Warcraft III installation containing files under a temporary directory
+// whose name does not match the app.
+func newTestLocal(t *testing.T, files map[string]string) (string, func()) {
+	t.Helper()
+	installDir, err := ioutil.TempDir("", "casc-local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataDir := filepath.Join(installDir, "Data", "data")
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	contents := map[[0x10]byte][]byte{}
+	root := bytes.NewBuffer(nil)
+	for name, content := range files {
+		ckey := md5.Sum([]byte(content))
+		contents[ckey] = []byte(content)
+		fmt.Fprintf(root, "%s|%x\n", name, ckey)
+	}
+	rootCKey := md5.Sum(root.Bytes())
+	contents[rootCKey] = root.Bytes()
+	encodingEntries := map[[0x10]byte][0x10]byte{}
+	encoded := map[[0x10]byte][]byte{}
+	encodedSizes := map[[0x10]byte]int{}
+	for ckey, content := range contents {
+		b := testBLTE(content)
+		ekey := md5.Sum(b)
+		encodingEntries[ckey] = ekey
+		encoded[ekey] = b
+		encodedSizes[ekey] = len(b)
+	}
+	encoding := testEncoding(encodingEntries, contents, encodedSizes)
+	encodingCKey := md5.Sum(encoding)
+	encodingEncoded := testBLTE(encoding)
+	encodingEKey := md5.Sum(encodingEncoded)
+	encoded[encodingEKey] = encodingEncoded
+
+	// data.000 and one .idx file per bucket
+	data := bytes.NewBuffer(nil)
+	idxEntries := map[uint8][]byte{}
+	for ekey, b := range encoded {
+		bucket, err := bucketID(ekey[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry := make([]byte, 18)
+		copy(entry, ekey[:9])
+		binary.BigEndian.PutUint32(entry[10:], uint32(data.Len())) // data.000
+		binary.LittleEndian.PutUint32(entry[14:], uint32(30+len(b)))
+		idxEntries[bucket] = append(idxEntries[bucket], entry...)
+
+		reversed := make([]byte, 16)
+		for i := range ekey {
+			reversed[15-i] = ekey[i]
+		}
+		data.Write(reversed)
+		binary.Write(data, binary.LittleEndian, uint32(30+len(b)))
+		data.Write(make([]byte, 10))
+		data.Write(b)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dataDir, "data.000"), data.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	for bucket, entries := range idxEntries {
+		header := common.IndexHeader{
+			BucketIndex:      bucket,
+			EntrySizeBytes:   4,
+			EntryOffsetBytes: 5,
+			EntryKeyBytes:    9,
+			EntriesSize:      uint32(len(entries)),
+		}
+		idx := bytes.NewBuffer(nil)
+		binary.Write(idx, binary.LittleEndian, header)
+		idx.Write(entries)
+		filename := filepath.Join(dataDir, fmt.Sprintf("%02x00000001.idx", bucket))
+		if err := ioutil.WriteFile(filename, idx.Bytes(), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buildConfig := []byte(fmt.Sprintf("root = %x\nencoding = %x %x\nbuild-product = War3\n",
+		rootCKey, encodingCKey, encodingEKey))
+	buildConfigHash := md5.Sum(buildConfig)
+	h := hex.EncodeToString(buildConfigHash[:])
+	configDir := filepath.Join(installDir, "Data", "config", h[0:2], h[2:4])
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(configDir, h), buildConfig, 0666); err != nil {
+		t.Fatal(err)
+	}
+	buildInfo := fmt.Sprintf("Branch!STRING:0|Active!DEC:1|Build Key!HEX:16|CDN Key!HEX:16|Version!STRING:0|Product!STRING:0\n"+
+		"eu|1|%s|00000000000000000000000000000000|1.0.0.1|w3\n", h)
+	if err := ioutil.WriteFile(filepath.Join(installDir, ".build.info"), []byte(buildInfo), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return installDir, func() {
+		if err := os.RemoveAll(installDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLocal(t *testing.T) {
+	files := map[string]string{
+		"War3.mpq:Units/Human/Footman.blp": "footman",
+		"War3.mpq:Units/Orc/Grunt.blp":     "grunt",
+	}
+	installDir, close := newTestLocal(t, files)
+	defer close()
+	explorer, err := Local(installDir)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if explorer.App() != Warcraft3 || explorer.Version() != "1.0.0.1" {
+		t.Fatalf("unexpected app %s and version %s", explorer.App(), explorer.Version())
+	}
+	for filename, content := range files {
+		b, err := explorer.Extract(filename)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if string(b) != content {
+			t.Fatalf("%s: expected %q got %q", filename, content, b)
+		}
+		b, err = explorer.ReadAt(filename, 1, 3)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if string(b) != content[1:4] {
+			t.Fatalf("%s: expected %q got %q", filename, content[1:4], b)
+		}
+		info, err := explorer.Stat(filename)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if info.Name != filename || info.Size != uint64(len(content)) ||
+			info.EncodedSize != uint64(len(testBLTE([]byte(content)))) || info.Archive != "data.000" {
+			t.Fatalf("%s: unexpected file info %+v", filename, info)
+		}
+	}
+	if _, err := Local(installDir, WithApp(Diablo3)); err == nil {
+		t.Fatal("expected inconsistent app error")
+	}
+}
+
+func TestLocalStatNotInstalled(t *testing.T) {
+	files := map[string]string{"War3.mpq:Units/Human/Footman.blp": "footman"}
+	installDir, close := newTestLocal(t, files)
+	defer close()
+	explorer, err := Local(installDir)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	// Content listed by the encoding file without any .idx entry, i.e. a locale that was not downloaded.
+	explorer.storage.(*local).idxs = map[uint8]*idxBucket{}
+	info, err := explorer.Stat("War3.mpq:Units/Human/Footman.blp")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if info.Size != 7 || info.EncodedSize != uint64(len(testBLTE([]byte("footman")))) ||
+		info.Archive != "" || info.ArchiveOffset != 0 {
+		t.Fatalf("unexpected file info %+v", info)
+	}
+	if _, err := explorer.Extract("War3.mpq:Units/Human/Footman.blp"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound got %+v", err)
+	}
+}
+
+func TestLocalExtractMany(t *testing.T) {
+	files := map[string]string{}
+	for i := 0; i < 20; i++ {
+		files[fmt.Sprintf("War3.mpq:file%02d", i)] = fmt.Sprintf("content %d", i)
+	}
+	installDir, close := newTestLocal(t, files)
+	defer close()
+	explorer, err := Local(installDir)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	results, err := explorer.ExtractAll(context.Background(), 4)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	count := 0
+	for result := range results {
+		count++
+		if result.Err != nil {
+			t.Fatalf("%s: %+v", result.Filename, result.Err)
+		}
+		if string(result.Data) != files[result.Filename] {
+			t.Fatalf("%s: expected %q got %q", result.Filename, files[result.Filename], result.Data)
+		}
+	}
+	if count != len(files) {
+		t.Fatalf("expected %d results got %d", len(files), count)
+	}
+
+	// Canceling stops the workers and closes the channel without receiving all results.
+	ctx, cancel := context.WithCancel(context.Background())
+	results, err = explorer.ExtractAll(ctx, 4)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	<-results
+	cancel()
+	count = 1
+	for range results {
+		count++
+	}
+	if count >= len(files) {
+		t.Fatalf("expected fewer than %d results got %d", len(files), count)
+	}
+}
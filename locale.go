@@ -0,0 +1,138 @@
+package casc
+
+import (
+	"sort"
+	"strings"
+)
+
+// variantRoot is implemented by roots whose files can be locale or platform specific
+// variants of a logical file.
+type variantRoot interface {
+	root
+	// Variant returns the logical filename of filename and the locale and the platform
+	// filename is specific to. locale and platform are empty if filename is not specific to one.
+	Variant(filename string) (logical, locale, platform string)
+}
+
+// localizedRoot resolves logical filenames to the variant of a locale and a platform.
+// Without locale nor platform, the filenames of the underlying root are exposed as is.
+// Logical filenames are case insensitive since variants do not always share the case
+// of the file they replace (i.e. StarCraft "locales/deDE/Assets/SD/Smk/blizzard.webm"
+// and "SD/smk/Blizzard.webm").
+type localizedRoot struct {
+	root     variantRoot
+	selected bool
+	names    map[string]string   // lower case logical filename => logical filename
+	variants map[string]string   // lower case logical filename => filename within root
+	locales  map[string][]string // lower case logical filename => locales it exists in
+}
+
+// newLocalizedRoot indexes the variants of the files of r.
+// Locale specific files are exposed by their logical filename if locale is not empty,
+// platform specific files are exposed by their logical filename if platform is not empty.
+// The variant of locale takes precedence over the variant of platform which takes
+// precedence over the file that is specific to neither.
+func newLocalizedRoot(r variantRoot, locale, platform string) (*localizedRoot, error) {
+	filenames, err := r.Files()
+	if err != nil {
+		return nil, err
+	}
+	lr := &localizedRoot{
+		root:     r,
+		selected: locale != "" || platform != "",
+		names:    map[string]string{},
+		variants: map[string]string{},
+		locales:  map[string][]string{},
+	}
+	ranks := map[string]int{}
+	for _, filename := range filenames {
+		logical, fileLocale, filePlatform := r.Variant(filename)
+		if fileLocale != "" {
+			key := strings.ToLower(logical)
+			lr.locales[key] = appendLocale(lr.locales[key], fileLocale)
+		}
+		if !lr.selected {
+			continue
+		}
+		if (fileLocale != "" && locale == "") || (filePlatform != "" && platform == "") {
+			logical, fileLocale, filePlatform = filename, "", ""
+		}
+		if (fileLocale != "" && fileLocale != locale) || (filePlatform != "" && filePlatform != platform) {
+			continue
+		}
+		rank := 0
+		if fileLocale != "" {
+			rank = 2
+		} else if filePlatform != "" {
+			rank = 1
+		}
+		key := strings.ToLower(logical)
+		if prev, ok := ranks[key]; ok && prev >= rank {
+			continue
+		}
+		ranks[key] = rank
+		lr.names[key] = logical
+		lr.variants[key] = filename
+	}
+	for _, locales := range lr.locales {
+		sort.Strings(locales)
+	}
+	return lr, nil
+}
+
+func (r *localizedRoot) Files() ([]string, error) {
+	if !r.selected {
+		return r.root.Files()
+	}
+	names := make([]string, 0, len(r.names))
+	for _, name := range r.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ContentHash returns the content hash of the variant of the logical filename.
+// filename can also be the filename of any variant within the underlying root.
+func (r *localizedRoot) ContentHash(filename string) ([]byte, error) {
+	if name, ok := r.variants[strings.ToLower(filename)]; ok {
+		return r.root.ContentHash(name)
+	}
+	return r.root.ContentHash(filename)
+}
+
+// localesRoot is implemented by roots listing the locales of their files.
+type localesRoot interface {
+	Locales(filename string) ([]string, error)
+}
+
+// Locales returns the locales the file with the given filename exists in.
+// The locales of localized files are returned for filenames of the underlying root and
+// for logical filenames, i.e. "enUS-War3Local.mpq:UI/Foo.fdf" and "War3Local.mpq:UI/Foo.fdf"
+// both return all locales of the Warcraft III file.
+// Returns nil if the file is not localized.
+// Returns the error of the root if the file was not found.
+func (e Explorer) Locales(filename string) ([]string, error) {
+	if r, ok := e.root.(localesRoot); ok {
+		return r.Locales(filename)
+	}
+	if _, err := e.root.ContentHash(filename); err != nil {
+		return nil, err
+	}
+	lr, ok := e.root.(*localizedRoot)
+	if !ok {
+		return nil, nil
+	}
+	logical, _, _ := lr.root.Variant(filename)
+	return append([]string(nil), lr.locales[strings.ToLower(logical)]...), nil
+}
+
+// appendLocale appends locale unless it is already within locales.
+func appendLocale(locales []string, locale string) []string {
+	for _, l := range locales {
+		if l == locale {
+			return locales
+		}
+	}
+	return append(locales, locale)
+}
@@ -0,0 +1,143 @@
+package casc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jybp/casc/root/diablo3"
+	"github.com/jybp/casc/root/starcraft1"
+)
+
+func TestWithLocale(t *testing.T) {
+	files := map[string]string{
+		"War3.mpq:Units/Human/Footman.blp": "footman",
+		"War3Local.mpq:UI/Help.txt":        "help",
+		"enUS-War3Local.mpq:UI/Help.txt":   "enUS help",
+		"frFR-War3Local.mpq:UI/Help.txt":   "frFR help",
+		"frFR-Maps/Campaign.w3m":           "frFR campaign",
+	}
+	cdn, close := newTestCDN(t, files)
+	defer close()
+
+	explorer, err := Mirror(cdn.dir, Warcraft3, cdn.buildConfigHash, cdn.cdnConfigHash)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	filenames, err := explorer.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filenames) != len(files) {
+		t.Fatalf("unexpected files %v", filenames)
+	}
+	locales, err := explorer.Locales("enUS-War3Local.mpq:UI/Help.txt")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(locales, []string{"enUS", "frFR"}) {
+		t.Fatalf("unexpected locales %v", locales)
+	}
+
+	explorer, err = Mirror(cdn.dir, Warcraft3, cdn.buildConfigHash, cdn.cdnConfigHash, WithLocale("frFR"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	filenames, err = explorer.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"Maps/Campaign.w3m", "War3.mpq:Units/Human/Footman.blp", "War3Local.mpq:UI/Help.txt"}
+	if !reflect.DeepEqual(filenames, expected) {
+		t.Fatalf("expected %v got %v", expected, filenames)
+	}
+	for filename, content := range map[string]string{
+		"War3Local.mpq:UI/Help.txt":      "frFR help",
+		"enUS-War3Local.mpq:UI/Help.txt": "enUS help",
+		"Maps/Campaign.w3m":              "frFR campaign",
+	} {
+		b, err := explorer.Extract(filename)
+		if err != nil {
+			t.Fatalf("%s: %+v", filename, err)
+		}
+		if string(b) != content {
+			t.Fatalf("%s: expected %q got %q", filename, content, b)
+		}
+	}
+	if locales, err = explorer.Locales("War3Local.mpq:UI/Help.txt"); err != nil || !reflect.DeepEqual(locales, []string{"enUS", "frFR"}) {
+		t.Fatalf("unexpected locales %v %+v", locales, err)
+	}
+	if locales, err = explorer.Locales("War3.mpq:Units/Human/Footman.blp"); err != nil || len(locales) != 0 {
+		t.Fatalf("unexpected locales %v %+v", locales, err)
+	}
+}
+
+func TestLocalizedRootCase(t *testing.T) {
+	s1, err := starcraft1.NewRoot([]byte("SD/smk/Blizzard.webm|00\n" +
+		"locales/deDE/Assets/SD/Smk/blizzard.webm|01\n" +
+		"locales/frFR/Assets/SD/smk/Blizzard.webm|02\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lr, err := newLocalizedRoot(s1, "deDE", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filenames, err := lr.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(filenames, []string{"SD/Smk/blizzard.webm"}) {
+		t.Fatalf("unexpected files %v", filenames)
+	}
+	for _, filename := range []string{"SD/smk/Blizzard.webm", "SD/Smk/blizzard.webm"} {
+		hash, err := lr.ContentHash(filename)
+		if err != nil {
+			t.Fatalf("%s: %+v", filename, err)
+		}
+		if !reflect.DeepEqual(hash, []byte{0x01}) {
+			t.Fatalf("%s: expected the deDE variant got %x", filename, hash)
+		}
+	}
+	explorer := &Explorer{root: lr}
+	locales, err := explorer.Locales("SD/smk/Blizzard.webm")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(locales, []string{"deDE", "frFR"}) {
+		t.Fatalf("unexpected locales %v", locales)
+	}
+}
+
+func TestUnknownLocale(t *testing.T) {
+	cdn, close := newTestCDN(t, map[string]string{"War3.mpq:Units/Human/Footman.blp": "footman"})
+	defer close()
+	if _, err := Mirror(cdn.dir, Warcraft3, cdn.buildConfigHash, cdn.cdnConfigHash, WithLocale("english")); err == nil {
+		t.Fatal("expected an unknown locale error")
+	}
+}
+
+func TestVariant(t *testing.T) {
+	s1, err := starcraft1.NewRoot(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		root                      variantRoot
+		filename                  string
+		logical, locale, platform string
+	}{
+		{s1, "locales/enUS/Assets/SD/glue/exiton.webm", "SD/glue/exiton.webm", "enUS", ""},
+		{s1, "locales/enUS/StarEditHelp/index.html", "StarEditHelp/index.html", "enUS", ""},
+		{s1, "SD/glue/exiton.webm", "SD/glue/exiton.webm", "", ""},
+		{&diablo3.Root{}, "enUS_Text/StringList/Items.stl", "StringList/Items.stl", "enUS", ""},
+		{&diablo3.Root{}, "Windows/Data_D3/PC/Foo.dat", "Data_D3/PC/Foo.dat", "", "Windows"},
+		{&diablo3.Root{}, "Base/CoreTOC.dat", "CoreTOC.dat", "", ""},
+		{&diablo3.Root{}, "Other/CoreTOC.dat", "Other/CoreTOC.dat", "", ""},
+	}
+	for _, test := range tests {
+		logical, locale, platform := test.root.Variant(test.filename)
+		if logical != test.logical || locale != test.locale || platform != test.platform {
+			t.Errorf("%s: unexpected variant %s %s %s", test.filename, logical, locale, platform)
+		}
+	}
+}
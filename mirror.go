@@ -0,0 +1,121 @@
+package casc
+
+import (
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/jybp/casc/common"
+	"github.com/pkg/errors"
+)
+
+// mirrorCDN reads files from a directory laid out like the CDN:
+// config/xx/yy/hash, data/xx/yy/hash, data/xx/yy/hash.index and likewise for patch/.
+// Missing files are reported as casc.ErrNotFound.
+type mirrorCDN struct {
+	dir string
+}
+
+func (m *mirrorCDN) path(pathType string, hash []byte, index bool) (string, error) {
+	return cdnFilePath(m.dir, pathType, hash, index)
+}
+
+// cdnFilePath returns the path of the file of type pathType named after hash
+// within dir laid out like the CDN.
+func cdnFilePath(dir, pathType string, hash []byte, index bool) (string, error) {
+	h := hex.EncodeToString(hash)
+	if len(h) < 4 {
+		return "", errors.WithStack(errors.New("invalid hash len"))
+	}
+	if index {
+		h += ".index"
+	}
+	return filepath.Join(dir, pathType, h[0:2], h[2:4], h), nil
+}
+
+func (m *mirrorCDN) get(pathType string, hash []byte, index bool) ([]byte, error) {
+	filename, err := m.path(pathType, hash, index)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, errors.Wrapf(ErrNotFound, "%s", filename)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return b, nil
+}
+
+func (m *mirrorCDN) openData(pathType string, key, archiveHash []byte, offset, size uint32) (io.ReadCloser, error) {
+	data, err := m.open(pathType, key, archiveHash, offset, size)
+	if err != nil {
+		// A nil *sectionReadCloser would be a non-nil io.ReadCloser.
+		return nil, err
+	}
+	return data, nil
+}
+
+func (m *mirrorCDN) openDataAt(key, archiveHash []byte, offset, size uint32) (*sectionReadCloser, error) {
+	return m.open(common.PathTypeData, key, archiveHash, offset, size)
+}
+
+// open opens the range of the archive of type pathType if present within the mirror.
+// Otherwise the content is read from the file of type pathType named after key,
+// which is how WithCacheDir stores archived content.
+func (m *mirrorCDN) open(pathType string, key, archiveHash []byte, offset, size uint32) (*sectionReadCloser, error) {
+	filename, err := m.path(pathType, archiveHash, false)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) && size > 0 {
+		if filename, err = m.path(pathType, key, false); err != nil {
+			return nil, err
+		}
+		offset, size = 0, 0
+		f, err = os.Open(filename)
+	}
+	if os.IsNotExist(err) {
+		return nil, errors.Wrapf(ErrNotFound, "%s", filename)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if size == 0 {
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, errors.WithStack(err)
+		}
+		offset, size = 0, uint32(fi.Size())
+	}
+	return &sectionReadCloser{io.NewSectionReader(f, int64(offset), int64(size)), f}, nil
+}
+
+// Mirror will use files located under dir to fetch CASC files.
+// dir must be laid out like the CDN (config/xx/yy/hash, data/xx/yy/hash and data/xx/yy/hash.index).
+// Patches are read from patch/xx/yy/hash and patch/xx/yy/hash.index.
+// A directory created by WithCacheDir can be used as a mirror.
+// app is the program code.
+// buildConfigHash and cdnConfigHash are the hex encoded hashes identifying the build.
+// Options related to the CDN or to the .build.info file are ignored.
+func Mirror(dir, app, buildConfigHash, cdnConfigHash string, opts ...Option) (*Explorer, error) {
+	buildCfgHash, err := hex.DecodeString(buildConfigHash)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	cdnCfgHash, err := hex.DecodeString(cdnConfigHash)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	o := newOptions(opts)
+	storage, err := newCDNStorage(app, "", buildCfgHash, cdnCfgHash, &mirrorCDN{dir}, o)
+	if err != nil {
+		return nil, err
+	}
+	return newExplorer(storage, o)
+}
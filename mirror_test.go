@@ -0,0 +1,424 @@
+package casc
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/jybp/casc/blte"
+)
+
+// testCDN is a build laid out like the CDN.
+type testCDN struct {
+	dir             string
+	buildConfigHash string
+	cdnConfigHash   string
+}
+
+// newTestCDN writes a Warcraft III build containing files under a temporary directory.
+// The root and encoding files are stored as loose data files, files within an archive.
+func newTestCDN(t *testing.T, files map[string]string) (testCDN, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "casc-cdn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFn := func(pathType string, hash []byte, index bool, b []byte) {
+		filename, err := cdnFilePath(dir, pathType, hash, index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, b, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	contents := map[[0x10]byte][]byte{}
+	root := bytes.NewBuffer(nil)
+	for _, name := range names {
+		ckey := md5.Sum([]byte(files[name]))
+		contents[ckey] = []byte(files[name])
+		fmt.Fprintf(root, "%s|%x\n", name, ckey)
+	}
+
+	// archive
+	archive := bytes.NewBuffer(nil)
+	var archiveIndex []byte
+	encodingEntries := map[[0x10]byte][0x10]byte{}
+	encodedSizes := map[[0x10]byte]int{}
+	for ckey, content := range contents {
+		encoded := testBLTE(content)
+		ekey := md5.Sum(encoded)
+		encodingEntries[ckey] = ekey
+		encodedSizes[ekey] = len(encoded)
+		entry := make([]byte, 0x18)
+		copy(entry, ekey[:])
+		binary.BigEndian.PutUint32(entry[0x10:], uint32(len(encoded)))
+		binary.BigEndian.PutUint32(entry[0x14:], uint32(archive.Len()))
+		archiveIndex = append(archiveIndex, entry...)
+		archive.Write(encoded)
+	}
+	footer := make([]byte, 12)
+	binary.LittleEndian.PutUint32(footer, uint32(len(contents)))
+	archiveIndex = append(archiveIndex, footer...)
+	archiveHash := md5.Sum(archiveIndex)
+	writeFn("data", archiveHash[:], false, archive.Bytes())
+	writeFn("data", archiveHash[:], true, archiveIndex)
+
+	// root
+	rootCKey := md5.Sum(root.Bytes())
+	rootEncoded := testBLTE(root.Bytes())
+	rootEKey := md5.Sum(rootEncoded)
+	encodingEntries[rootCKey] = rootEKey
+	writeFn("data", rootEKey[:], false, rootEncoded)
+
+	// install
+	install := testInstall(names, files)
+	installCKey := md5.Sum(install)
+	installEncoded := testBLTE(install)
+	installEKey := md5.Sum(installEncoded)
+	encodingEntries[installCKey] = installEKey
+	writeFn("data", installEKey[:], false, installEncoded)
+
+	// download
+	download := testDownload(names, files, encodingEntries)
+	downloadCKey := md5.Sum(download)
+	downloadEncoded := testBLTE(download)
+	downloadEKey := md5.Sum(downloadEncoded)
+	encodingEntries[downloadCKey] = downloadEKey
+	writeFn("data", downloadEKey[:], false, downloadEncoded)
+
+	// encoding
+	encoding := testEncoding(encodingEntries, contents, encodedSizes)
+	encodingCKey := md5.Sum(encoding)
+	encodingEncoded := testBLTE(encoding)
+	encodingEKey := md5.Sum(encodingEncoded)
+	writeFn("data", encodingEKey[:], false, encodingEncoded)
+
+	// configs
+	buildConfig := []byte(fmt.Sprintf("# Build Configuration\n\nroot = %x\nencoding = %x %x\ninstall = %x %x\ndownload = %x %x\nbuild-name = 1.0.0.1\nbuild-product = War3\n",
+		rootCKey, encodingCKey, encodingEKey, installCKey, installEKey, downloadCKey, downloadEKey))
+	buildConfigHash := md5.Sum(buildConfig)
+	writeFn("config", buildConfigHash[:], false, buildConfig)
+	cdnConfig := []byte(fmt.Sprintf("# CDN Configuration\n\narchives = %x\n", archiveHash))
+	cdnConfigHash := md5.Sum(cdnConfig)
+	writeFn("config", cdnConfigHash[:], false, cdnConfig)
+
+	return testCDN{
+		dir:             dir,
+		buildConfigHash: hex.EncodeToString(buildConfigHash[:]),
+		cdnConfigHash:   hex.EncodeToString(cdnConfigHash[:]),
+	}, func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testBLTE encodes b as uncompressed BLTE chunks of 16 bytes.
+func testBLTE(b []byte) []byte {
+	buf := bytes.NewBuffer(nil)
+	w, err := blte.NewWriter(buf, "b:{16*=n}")
+	if err != nil {
+		panic(err)
+	}
+	w.Write(b)
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// testInstall creates an install manifest where all files are tagged "Windows"
+// and only the first file is tagged "enUS". No file is tagged "OSX".
+func testInstall(names []string, files map[string]string) []byte {
+	mask := make([]byte, (len(names)+7)/8)
+	for i := range names {
+		mask[i/8] |= 0x80 >> uint(i%8)
+	}
+	b := bytes.NewBuffer(nil)
+	b.Write([]byte{'I', 'N', 1, 0x10, 0, 3})
+	binary.Write(b, binary.BigEndian, uint32(len(names)))
+	b.WriteString("Windows\x00")
+	b.Write([]byte{0, 1})
+	b.Write(mask)
+	b.WriteString("OSX\x00")
+	b.Write([]byte{0, 1})
+	b.Write(make([]byte, len(mask)))
+	b.WriteString("enUS\x00")
+	b.Write([]byte{0, 3})
+	enUS := make([]byte, len(mask))
+	enUS[0] = 0x80
+	b.Write(enUS)
+	for _, name := range names {
+		ckey := md5.Sum([]byte(files[name]))
+		b.WriteString(name + "\x00")
+		b.Write(ckey[:])
+		binary.Write(b, binary.BigEndian, uint32(len(files[name])))
+	}
+	return b.Bytes()
+}
+
+// testDownload creates a download manifest where all files are tagged "Windows".
+// The priority of the first file is 1, the priority of the other files is 0.
+func testDownload(names []string, files map[string]string, ekeys map[[0x10]byte][0x10]byte) []byte {
+	b := bytes.NewBuffer(nil)
+	b.Write([]byte{'D', 'L', 1, 0x10, 0})
+	binary.Write(b, binary.BigEndian, uint32(len(names)))
+	b.Write([]byte{0, 1})
+	mask := make([]byte, (len(names)+7)/8)
+	for i, name := range names {
+		ekey := ekeys[md5.Sum([]byte(files[name]))]
+		b.Write(ekey[:])
+		b.Write([]byte{0, 0, 0, 0, byte(len(files[name]))})
+		if i == 0 {
+			b.WriteByte(1)
+		} else {
+			b.WriteByte(0)
+		}
+		mask[i/8] |= 0x80 >> uint(i%8)
+	}
+	b.WriteString("Windows\x00")
+	b.Write([]byte{0, 1})
+	b.Write(mask)
+	return b.Bytes()
+}
+
+// testEncoding creates an encoding file with a single CKey page and a single EKey page.
+// The EKey page lists the encoded keys of encodedSizes with the ESpec of testBLTE.
+func testEncoding(entries map[[0x10]byte][0x10]byte, contents map[[0x10]byte][]byte, encodedSizes map[[0x10]byte]int) []byte {
+	ckeys := [][0x10]byte{}
+	for ckey := range entries {
+		ckeys = append(ckeys, ckey)
+	}
+	sort.Slice(ckeys, func(i, j int) bool { return bytes.Compare(ckeys[i][:], ckeys[j][:]) < 0 })
+	page := make([]byte, 1024)
+	offset := 0
+	for _, ckey := range ckeys {
+		ekey := entries[ckey]
+		page[offset] = 1 // key count
+		binary.BigEndian.PutUint32(page[offset+2:], uint32(len(contents[ckey])))
+		copy(page[offset+6:], ckey[:])
+		copy(page[offset+22:], ekey[:])
+		offset += 38
+	}
+	pageChecksum := md5.Sum(page)
+
+	ekeys := [][0x10]byte{}
+	for ekey := range encodedSizes {
+		ekeys = append(ekeys, ekey)
+	}
+	sort.Slice(ekeys, func(i, j int) bool { return bytes.Compare(ekeys[i][:], ekeys[j][:]) < 0 })
+	ePage := make([]byte, 1024)
+	offset = 0
+	for _, ekey := range ekeys {
+		copy(ePage[offset:], ekey[:])
+		// ESpec index 0 followed by the 40 bits encoded size.
+		binary.BigEndian.PutUint32(ePage[offset+21:], uint32(encodedSizes[ekey]))
+		offset += 25
+	}
+	ePageChecksum := md5.Sum(ePage)
+
+	espec := "b:{16*=n}\x00"
+	b := bytes.NewBuffer(nil)
+	b.Write([]byte{'E', 'N', 1, 0x10, 0x10, 0, 1, 0, 1, 0, 0, 0, 1, 0, 0, 0, 1, 0})
+	binary.Write(b, binary.BigEndian, uint32(len(espec)))
+	b.WriteString(espec)
+	b.Write(ckeys[0][:])
+	b.Write(pageChecksum[:])
+	b.Write(page)
+	b.Write(ekeys[0][:])
+	b.Write(ePageChecksum[:])
+	b.Write(ePage)
+	return b.Bytes()
+}
+
+// testMirrorFiles are the files of the build opened by newTestMirror.
+var testMirrorFiles = map[string]string{
+	"War3.mpq:Units/Human/Footman.blp": "footman",
+	"War3.mpq:Units/Orc/Grunt.blp":     "grunt",
+}
+
+// newTestMirror opens the mirror of a build containing testMirrorFiles.
+func newTestMirror(t *testing.T) (testCDN, *Explorer, func()) {
+	t.Helper()
+	cdn, close := newTestCDN(t, testMirrorFiles)
+	explorer, err := Mirror(cdn.dir, Warcraft3, cdn.buildConfigHash, cdn.cdnConfigHash)
+	if err != nil {
+		close()
+		t.Fatalf("%+v", err)
+	}
+	return cdn, explorer, close
+}
+
+func TestMirror(t *testing.T) {
+	files := testMirrorFiles
+	cdn, explorer, close := newTestMirror(t)
+	defer close()
+	if explorer.Version() != "1.0.0.1" {
+		t.Fatalf("unexpected version %s", explorer.Version())
+	}
+	filenames, err := explorer.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filenames) != len(files) {
+		t.Fatalf("unexpected files %v", filenames)
+	}
+	for _, filename := range filenames {
+		b, err := explorer.Extract(filename)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if string(b) != files[filename] {
+			t.Fatalf("%s: expected %q got %q", filename, files[filename], b)
+		}
+	}
+	if _, err := Mirror(cdn.dir, Diablo3, cdn.buildConfigHash, cdn.cdnConfigHash); err == nil {
+		t.Fatal("expected inconsistent app error")
+	}
+}
+
+func TestMirrorInstall(t *testing.T) {
+	_, explorer, close := newTestMirror(t)
+	defer close()
+	installed, err := explorer.InstallFiles("Windows", "enUS")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(installed) != 1 || installed[0].Name != "War3.mpq:Units/Human/Footman.blp" || installed[0].Size != 7 {
+		t.Fatalf("unexpected install entries %+v", installed)
+	}
+	if installed, err = explorer.InstallFiles("OSX"); err != nil || len(installed) != 0 {
+		t.Fatalf("unexpected install entries %+v %+v", installed, err)
+	}
+}
+
+func TestMirrorDownload(t *testing.T) {
+	_, explorer, close := newTestMirror(t)
+	defer close()
+	download, err := explorer.Download()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	downloaded, err := download.Filter("Windows")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(downloaded) != 2 || downloaded[0].Priority != 0 || downloaded[1].Priority != 1 {
+		t.Fatalf("unexpected download entries %+v", downloaded)
+	}
+	gruntEKey := md5.Sum(testBLTE([]byte("grunt")))
+	if !bytes.Equal(downloaded[0].EncodedHash, gruntEKey[:]) {
+		t.Fatalf("expected grunt first got %x", downloaded[0].EncodedHash)
+	}
+}
+
+func TestMirrorExtractByHash(t *testing.T) {
+	_, explorer, close := newTestMirror(t)
+	defer close()
+	footmanHash := md5.Sum([]byte("footman"))
+	if b, err := explorer.ExtractByContentHash(footmanHash[:]); err != nil || string(b) != "footman" {
+		t.Fatalf("unexpected content %q %+v", b, err)
+	}
+	gruntEKey := md5.Sum(testBLTE([]byte("grunt")))
+	if b, err := explorer.ExtractByEncodedKey(gruntEKey[:]); err != nil || string(b) != "grunt" {
+		t.Fatalf("unexpected content %q %+v", b, err)
+	}
+}
+
+func TestMirrorStat(t *testing.T) {
+	_, explorer, close := newTestMirror(t)
+	defer close()
+	footmanHash := md5.Sum([]byte("footman"))
+	info, err := explorer.Stat("War3.mpq:Units/Human/Footman.blp")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	footmanEncoded := testBLTE([]byte("footman"))
+	footmanEKey := md5.Sum(footmanEncoded)
+	if info.Size != 7 || !bytes.Equal(info.ContentHash, footmanHash[:]) ||
+		len(info.EncodedKeys) != 1 || !bytes.Equal(info.EncodedKeys[0], footmanEKey[:]) ||
+		info.EncodedSize != uint64(len(footmanEncoded)) || info.ESpec != "b:{16*=n}" || info.Archive == "" {
+		t.Fatalf("unexpected file info %+v", info)
+	}
+	if len(info.Tags) != 2 || info.Tags[0] != "Windows" || info.Tags[1] != "enUS" {
+		t.Fatalf("unexpected tags %v", info.Tags)
+	}
+}
+
+func TestMirrorStatInvalidManifest(t *testing.T) {
+	cdn, explorer, close := newTestMirror(t)
+	defer close()
+	names := []string{}
+	for name := range testMirrorFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	installEKey := md5.Sum(testBLTE(testInstall(names, testMirrorFiles)))
+	installPath, err := cdnFilePath(cdn.dir, "data", installEKey[:], false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	install, err := ioutil.ReadFile(installPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(installPath, []byte("invalid"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	info, err := explorer.Stat("War3.mpq:Units/Human/Footman.blp")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if info.Size != 7 || len(info.Tags) != 0 {
+		t.Fatalf("unexpected file info %+v", info)
+	}
+
+	// The manifests are not loaded again by the next call.
+	if err := ioutil.WriteFile(installPath, install, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if info, err = explorer.Stat("War3.mpq:Units/Human/Footman.blp"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(info.Tags) != 0 {
+		t.Fatalf("unexpected tags %v", info.Tags)
+	}
+}
+
+func TestMirrorReadAt(t *testing.T) {
+	_, explorer, close := newTestMirror(t)
+	defer close()
+	b, err := explorer.ReadAt("War3.mpq:Units/Orc/Grunt.blp", 2, 10)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if string(b) != "unt" {
+		t.Fatalf("unexpected content %q", b)
+	}
+}
+
+func TestMirrorOpenDataMissing(t *testing.T) {
+	dir, close := testTempDir(t)
+	defer close()
+	rc, err := (&mirrorCDN{dir}).openData("data", []byte{0x01, 0x23}, []byte{0x45, 0x67}, 0, 0)
+	if err == nil || rc != nil {
+		t.Fatalf("expected a nil io.ReadCloser and an error got %v %v", rc, err)
+	}
+}
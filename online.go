@@ -5,7 +5,11 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
 
 	"io/ioutil"
 
@@ -21,17 +25,26 @@ type archiveIndex struct {
 }
 
 type online struct {
-	app             string
-	versionName     string
-	rootEncodedHash []byte
-	encoding        map[string][][]byte
-	archivesIndices []archiveIndex
-	client          *http.Client
-	cdnHost         string
-	cdnPath         string
+	app                 string
+	versionName         string
+	rootEncodedHash     []byte
+	installHash         []byte
+	downloadHash        []byte
+	patchHash           []byte
+	patchConfigHash     []byte
+	encoding            *common.Encoding
+	archivesIndices     map[[0x10]byte]archiveIndex
+	patchArchivesHashes [][]byte
+	cdn                 cdnSource
+	blteOpts            []blte.Option
+
+	// patchArchivesIndices are loaded by the first call to Patch
+	// since only a Patcher needs them.
+	patchMu              sync.Mutex
+	patchArchivesIndices map[[0x10]byte]archiveIndex
 }
 
-func newOnlineStorage(app, region, cdnRegion string, client *http.Client) (*online, error) {
+func newOnlineStorage(app, region, cdnRegion string, client *http.Client, opts options) (*online, error) {
 	downloadFn := func(rawurl string) (b []byte, err error) {
 		resp, err := client.Get(rawurl)
 		if err != nil {
@@ -52,23 +65,44 @@ func newOnlineStorage(app, region, cdnRegion string, client *http.Client) (*onli
 	// Set versionName
 	//
 
-	versionsB, err := downloadFn(common.NGDPVersionsURL(app, cdnRegion))
-	if err != nil {
-		return nil, err
-	}
-	versions, err := common.ParseOnlineVersions(bytes.NewReader(versionsB))
-	if err != nil {
-		return nil, err
-	}
+	// versions and cdns are not cached since they change with each new build.
 	var version common.Version
-	for _, v := range versions {
-		if v.Region == region {
-			version = v
-			break
+	if len(opts.buildConfigHash) > 0 || len(opts.cdnConfigHash) > 0 {
+		// The build is pinned; the build-name of the build config is used as versionName.
+		buildConfigHash, err := hex.DecodeString(opts.buildConfigHash)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		cdnConfigHash, err := hex.DecodeString(opts.cdnConfigHash)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if len(buildConfigHash) == 0 || len(cdnConfigHash) == 0 {
+			return nil, errors.WithStack(errors.New("both build config and cdn config hashes are required"))
+		}
+		version = common.Version{
+			Region:          region,
+			BuildConfigHash: buildConfigHash,
+			CDNConfigHash:   cdnConfigHash,
+		}
+	} else {
+		versionsB, err := downloadFn(common.NGDPVersionsURL(app, cdnRegion))
+		if err != nil {
+			return nil, err
+		}
+		versions, err := common.ParseOnlineVersions(bytes.NewReader(versionsB))
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range versions {
+			if v.Region == region {
+				version = v
+				break
+			}
+		}
+		if len(version.Region) == 0 {
+			return nil, errors.WithStack(fmt.Errorf("version with region %s not found", region))
 		}
-	}
-	if len(version.Region) == 0 {
-		return nil, errors.WithStack(fmt.Errorf("version with region %s not found", region))
 	}
 
 	//
@@ -83,18 +117,27 @@ func newOnlineStorage(app, region, cdnRegion string, client *http.Client) (*onli
 	if err != nil {
 		return nil, err
 	}
-	cdn, ok := cdns[region]
+	cdnInfo, ok := cdns[region]
 	if !ok {
 		return nil, errors.WithStack(fmt.Errorf("cdn with region %s not found", region))
 	}
-	if len(cdn.Hosts) == 0 {
+	if len(cdnInfo.Hosts) == 0 {
 		return nil, errors.WithStack(errors.New("no cdn hosts"))
 	}
-	buildCfgURL, err := common.Url(cdn.Hosts[0], cdn.Path, common.PathTypeConfig, version.BuildConfigHash, false)
-	if err != nil {
-		return nil, err
+	cdn := &cdnClient{
+		client:   client,
+		host:     cdnInfo.Hosts[0],
+		path:     cdnInfo.Path,
+		cacheDir: opts.cacheDir,
 	}
-	buildCfgB, err := downloadFn(buildCfgURL)
+	return newCDNStorage(app, version.Name, version.BuildConfigHash, version.CDNConfigHash, cdn, opts)
+}
+
+// newCDNStorage creates a storage fetching the build identified by its build config
+// and CDN config hashes from cdn.
+// If versionName is empty, the build-name of the build config is used.
+func newCDNStorage(app, versionName string, buildConfigHash, cdnConfigHash []byte, cdn cdnSource, opts options) (*online, error) {
+	buildCfgB, err := cdn.get(common.PathTypeConfig, buildConfigHash, false)
 	if err != nil {
 		return nil, err
 	}
@@ -103,6 +146,12 @@ func newOnlineStorage(app, region, cdnRegion string, client *http.Client) (*onli
 	if err != nil {
 		return nil, err
 	}
+	if versionName == "" {
+		versionName = buildCfg.BuildName
+	}
+	if buildApp, ok := productToApps[buildCfg.BuildProduct]; ok && buildApp != app {
+		return nil, errors.WithStack(errors.Errorf("inconsistent app %s != %s", app, buildApp))
+	}
 
 	//
 	// Set encoding
@@ -111,11 +160,7 @@ func newOnlineStorage(app, region, cdnRegion string, client *http.Client) (*onli
 	if len(buildCfg.EncodingHashes) < 2 {
 		return nil, errors.WithStack(errors.New("expected at least two encoding hash"))
 	}
-	encodingURL, err := common.Url(cdn.Hosts[0], cdn.Path, common.PathTypeData, buildCfg.EncodingHashes[1], false)
-	if err != nil {
-		return nil, err
-	}
-	encodingBlteB, err := downloadFn(encodingURL)
+	encodingBlteB, err := cdn.get(common.PathTypeData, buildCfg.EncodingHashes[1], false)
 	if err != nil {
 		return nil, err
 	}
@@ -136,26 +181,42 @@ func newOnlineStorage(app, region, cdnRegion string, client *http.Client) (*onli
 	//
 	// Set archivesIndices
 	//
-	cdnCfgURL, err := common.Url(cdn.Hosts[0], cdn.Path, common.PathTypeConfig, version.CDNConfigHash, false)
+	cdnCfgB, err := cdn.get(common.PathTypeConfig, cdnConfigHash, false)
 	if err != nil {
 		return nil, err
 	}
-	cdnCfgB, err := downloadFn(cdnCfgURL)
+
+	cdnCfg, err := common.ParseCdnConfig(bytes.NewReader(cdnCfgB))
 	if err != nil {
 		return nil, err
 	}
-
-	cdnCfg, err := common.ParseCdnConfig(bytes.NewReader(cdnCfgB))
+	archivesIndices, err := parseArchivesIndices(cdn, common.PathTypeData, cdnCfg.ArchivesHashes)
 	if err != nil {
 		return nil, err
 	}
-	archivesIndices := []archiveIndex{}
-	for _, archiveHash := range cdnCfg.ArchivesHashes {
-		archiveIndexURL, err := common.Url(cdn.Hosts[0], cdn.Path, common.PathTypeData, archiveHash, true)
-		if err != nil {
-			return nil, err
-		}
-		indicesB, err := downloadFn(archiveIndexURL)
+	fmt.Fprintf(common.Wlog, "%d archive indices parsed\n", len(archivesIndices))
+	return &online{
+		app:                 app,
+		versionName:         versionName,
+		rootEncodedHash:     buildCfg.RootHash,
+		installHash:         contentHash(buildCfg.InstallHashes),
+		downloadHash:        contentHash(buildCfg.DownloadHashes),
+		patchHash:           buildCfg.PatchHash,
+		patchConfigHash:     buildCfg.PatchConfigHash,
+		encoding:            encoding,
+		archivesIndices:     archivesIndices,
+		patchArchivesHashes: cdnCfg.PatchArchivesHashes,
+		cdn:                 cdn,
+		blteOpts:            opts.blteOptions(),
+	}, nil
+}
+
+// parseArchivesIndices indexes the entries of the archives of type pathType by encoded key.
+// If several archives contain the same encoded key, the first archive is used.
+func parseArchivesIndices(cdn cdnSource, pathType string, archivesHashes [][]byte) (map[[0x10]byte]archiveIndex, error) {
+	archivesIndices := map[[0x10]byte]archiveIndex{}
+	for _, archiveHash := range archivesHashes {
+		indicesB, err := cdn.get(pathType, archiveHash, true)
 		if err != nil {
 			return nil, err
 		}
@@ -164,20 +225,13 @@ func newOnlineStorage(app, region, cdnRegion string, client *http.Client) (*onli
 			return nil, err
 		}
 		for _, index := range indices {
-			archivesIndices = append(archivesIndices, archiveIndex{index, archiveHash})
+			if _, ok := archivesIndices[index.HeaderHash]; ok {
+				continue
+			}
+			archivesIndices[index.HeaderHash] = archiveIndex{index, archiveHash}
 		}
 	}
-	fmt.Fprintf(common.Wlog, "%d archive indices parsed\n", len(archivesIndices))
-	return &online{
-		app:             app,
-		versionName:     version.Name,
-		rootEncodedHash: buildCfg.RootHash,
-		encoding:        encoding,
-		archivesIndices: archivesIndices,
-		client:          client,
-		cdnHost:         cdn.Hosts[0],
-		cdnPath:         cdn.Path,
-	}, nil
+	return archivesIndices, nil
 }
 
 func (s *online) App() string {
@@ -192,60 +246,405 @@ func (s *online) RootHash() []byte {
 	return s.rootEncodedHash
 }
 
+func (s *online) InstallHash() []byte {
+	return s.installHash
+}
+
+func (s *online) DownloadHash() []byte {
+	return s.downloadHash
+}
+
 func (s *online) FromContentHash(hash []byte) ([]byte, error) {
-	encodedHashes, ok := s.encoding[hex.EncodeToString(hash)]
+	return readAll(s.OpenContentHash(hash))
+}
+
+func (s *online) OpenContentHash(hash []byte) (io.ReadCloser, error) {
+	encodedHashes, ok := s.encoding.EncodedHashes(hash)
 	if !ok || len(encodedHashes) == 0 {
-		return nil, errors.WithStack(errors.Errorf("encoded hash not found for decoded hash %x", hash))
+		return nil, ErrNotFound
 	}
-	return s.dataFromEncodedHash(encodedHashes[0])
+	return s.OpenEncodedHash(encodedHashes[0])
 }
 
-func (s *online) dataFromEncodedHash(hash []byte) ([]byte, error) {
-	downloadFn := func(hash []byte, offset, size uint32) (b []byte, err error) {
-		url, err := common.Url(s.cdnHost, s.cdnPath, common.PathTypeData, hash, false)
-		if err != nil {
-			return nil, err
+// OpenEncodedHash returns a reader decoding the BLTE content of hash while it is downloaded
+// or read from the cache.
+// The underlying data is closed with the returned io.ReadCloser.
+func (s *online) OpenEncodedHash(hash []byte) (rc io.ReadCloser, err error) {
+	var encoded io.ReadCloser
+	archiveIdx, ok := s.archiveIndex(hash)
+	if ok {
+		encoded, err = s.cdn.openData(common.PathTypeData, hash, archiveIdx.archiveHash, archiveIdx.Offset, archiveIdx.EncodedSize)
+	} else {
+		encoded, err = s.cdn.openData(common.PathTypeData, hash, hash, 0, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	blteReader, err := blte.NewReader(encoded, s.blteOpts...)
+	if err != nil {
+		// The decoding error is more relevant than a close error.
+		encoded.Close()
+		return nil, errors.WithStack(err)
+	}
+	return &readCloser{blteReader, encoded}, nil
+}
+
+func (s *online) ReadContentHashAt(hash, p []byte, off int64) (int, error) {
+	encodedHashes, ok := s.encoding.EncodedHashes(hash)
+	if !ok || len(encodedHashes) == 0 {
+		return 0, ErrNotFound
+	}
+	ekey := encodedHashes[0]
+	var data *sectionReadCloser
+	var err error
+	if archiveIdx, ok := s.archiveIndex(ekey); ok {
+		data, err = s.cdn.openDataAt(ekey, archiveIdx.archiveHash, archiveIdx.Offset, archiveIdx.EncodedSize)
+	} else {
+		data, err = s.cdn.openDataAt(ekey, ekey, 0, 0)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return readEncodedAt(data, s.blteOpts, p, off)
+}
+
+func (s *online) Stat(hash []byte) (FileInfo, error) {
+	info, err := encodingFileInfo(s.encoding, hash)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if archiveIdx, ok := s.archiveIndex(info.EncodedKeys[0]); ok {
+		info.Archive = hex.EncodeToString(archiveIdx.archiveHash)
+		info.ArchiveOffset = int64(archiveIdx.Offset)
+		if info.EncodedSize == 0 {
+			info.EncodedSize = uint64(archiveIdx.EncodedSize)
 		}
-		req, err := http.NewRequest(http.MethodGet, url, nil)
-		if err != nil {
-			return nil, errors.WithStack(err)
+	}
+	return info, nil
+}
+
+func (s *online) PatchHash() []byte {
+	return s.patchHash
+}
+
+// Patch returns the patch named after hash from the patch archives
+// or from its loose file if it is not within a patch archive.
+func (s *online) Patch(hash []byte) ([]byte, error) {
+	patchArchivesIndices, err := s.loadPatchArchivesIndices()
+	if err != nil {
+		return nil, err
+	}
+	if len(hash) == 0x10 {
+		var key [0x10]byte
+		copy(key[:], hash)
+		if idx, ok := patchArchivesIndices[key]; ok {
+			return readAll(s.cdn.openData(common.PathTypePatch, hash, idx.archiveHash, idx.Offset, idx.EncodedSize))
 		}
-		if size > 0 {
-			req.Header.Add("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+size))
+	}
+	return s.cdn.get(common.PathTypePatch, hash, false)
+}
+
+// loadPatchArchivesIndices returns the patch archive entries indexed by patch hash.
+// A failed load is retried by the next call.
+func (s *online) loadPatchArchivesIndices() (map[[0x10]byte]archiveIndex, error) {
+	s.patchMu.Lock()
+	defer s.patchMu.Unlock()
+	if s.patchArchivesIndices != nil {
+		return s.patchArchivesIndices, nil
+	}
+	patchArchivesIndices, err := parseArchivesIndices(s.cdn, common.PathTypePatch, s.patchArchivesHashes)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(common.Wlog, "%d patch archive indices parsed\n", len(patchArchivesIndices))
+	s.patchArchivesIndices = patchArchivesIndices
+	return patchArchivesIndices, nil
+}
+
+func (s *online) PatchConfig() (*common.PatchConfig, error) {
+	if len(s.patchConfigHash) == 0 {
+		return nil, nil
+	}
+	b, err := s.cdn.get(common.PathTypeConfig, s.patchConfigHash, false)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := common.ParsePatchConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// contentHash returns the content hash of a build config entry made of
+// a content hash optionally followed by an encoded hash, or nil if hashes is empty.
+func contentHash(hashes [][]byte) []byte {
+	if len(hashes) == 0 {
+		return nil
+	}
+	return hashes[0]
+}
+
+// archiveIndex returns the archive entry of the encoded hash.
+// ok is false if hash is not within an archive.
+func (s *online) archiveIndex(hash []byte) (idx archiveIndex, ok bool) {
+	if len(hash) != 0x10 {
+		return archiveIndex{}, false
+	}
+	var key [0x10]byte
+	copy(key[:], hash)
+	idx, ok = s.archivesIndices[key]
+	return idx, ok
+}
+
+// cdnSource fetches files laid out like the CDN.
+// Implementations must be safe for concurrent use.
+type cdnSource interface {
+	// get returns the content of the file of type pathType named after hash.
+	get(pathType string, hash []byte, index bool) ([]byte, error)
+	// openData opens size bytes located at offset within the file of type pathType
+	// named after archiveHash. The whole file is opened if size is zero.
+	// key is the encoded hash of the opened content.
+	openData(pathType string, key, archiveHash []byte, offset, size uint32) (io.ReadCloser, error)
+	// openDataAt is like openData but provides random access to the content.
+	openDataAt(key, archiveHash []byte, offset, size uint32) (*sectionReadCloser, error)
+}
+
+// cdnClient downloads files from a CDN.
+// If cacheDir is set, downloaded files are stored under cacheDir using the CDN layout
+// and are read from there on subsequent calls.
+// Files are named after their hash so the cache never needs to be invalidated.
+type cdnClient struct {
+	client   *http.Client
+	host     string
+	path     string
+	cacheDir string
+}
+
+// get returns the content of the file of type pathType named after hash.
+func (c *cdnClient) get(pathType string, hash []byte, index bool) ([]byte, error) {
+	cached, err := c.cachePath(pathType, hash, index)
+	if err != nil {
+		return nil, err
+	}
+	if cached != "" {
+		b, err := ioutil.ReadFile(cached)
+		if err == nil {
+			return b, nil
 		}
-		resp, err := s.client.Do(req)
-		if err != nil {
+		if !os.IsNotExist(err) {
 			return nil, errors.WithStack(err)
 		}
-		defer func() {
-			if cerr := resp.Body.Close(); cerr != nil {
-				err = cerr
-			}
-		}()
-		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			return nil, errors.WithStack(fmt.Errorf("(%d) %s ", resp.StatusCode, url))
-		}
-		return ioutil.ReadAll(resp.Body)
 	}
-	decodeBlteFn := func(encoded io.Reader) ([]byte, error) {
-		blteReader, err := blte.NewReader(encoded)
-		if err != nil {
-			return nil, errors.WithStack(err)
+	b, err := readAll(c.download(pathType, hash, index, 0, 0))
+	if err != nil {
+		return nil, err
+	}
+	if cached != "" {
+		if err := writeCache(cached, bytes.NewReader(b), 0); err != nil {
+			return nil, err
 		}
-		return ioutil.ReadAll(blteReader)
 	}
-	for _, idx := range s.archivesIndices {
-		if bytes.Compare(hash, idx.HeaderHash[:]) == 0 {
-			b, err := downloadFn(idx.archiveHash, idx.Offset, idx.EncodedSize)
+	return b, nil
+}
+
+// openData opens size bytes located at offset within the file of type pathType named after archiveHash.
+// The whole file is opened if size is zero.
+// key is the encoded hash of the opened content which is used to name its cached file.
+func (c *cdnClient) openData(pathType string, key, archiveHash []byte, offset, size uint32) (io.ReadCloser, error) {
+	cached, err := c.cachePath(pathType, key, false)
+	if err != nil {
+		return nil, err
+	}
+	if cached == "" {
+		return c.download(pathType, archiveHash, false, offset, size)
+	}
+	f, err := os.Open(cached)
+	if err == nil {
+		return f, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, errors.WithStack(err)
+	}
+	// download only succeeds if a range request is answered by a partial response,
+	// and writeCache checks its size, so that a whole archive is never cached under key.
+	rc, err := c.download(pathType, archiveHash, false, offset, size)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeCache(cached, rc, int64(size)); err != nil {
+		rc.Close()
+		return nil, err
+	}
+	if err := rc.Close(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	f, err = os.Open(cached)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return f, nil
+}
+
+// openDataAt is like openData but provides random access to the content.
+// If the content is not cached, each read requests the range it covers.
+// Partially read content is not cached.
+func (c *cdnClient) openDataAt(key, archiveHash []byte, offset, size uint32) (*sectionReadCloser, error) {
+	cached, err := c.cachePath(common.PathTypeData, key, false)
+	if err != nil {
+		return nil, err
+	}
+	if cached != "" {
+		f, err := os.Open(cached)
+		if err == nil {
+			fi, err := f.Stat()
 			if err != nil {
+				f.Close()
 				return nil, errors.WithStack(err)
 			}
-			return decodeBlteFn(bytes.NewReader(b))
+			return &sectionReadCloser{io.NewSectionReader(f, 0, fi.Size()), f}, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, errors.WithStack(err)
 		}
 	}
-	b, err := downloadFn(hash, 0, 0)
+	if size == 0 {
+		// The size of a file not within an archive is only known from the CDN.
+		length, err := c.contentLength(common.PathTypeData, archiveHash)
+		if err != nil {
+			return nil, err
+		}
+		size = uint32(length)
+	}
+	ra := &rangeReaderAt{c, archiveHash}
+	return &sectionReadCloser{io.NewSectionReader(ra, int64(offset), int64(size)), nopCloser{}}, nil
+}
+
+// rangeReaderAt reads the data file named after hash using Range requests.
+type rangeReaderAt struct {
+	c    *cdnClient
+	hash []byte
+}
+
+func (r *rangeReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	// Range requests are limited to the 32 bits offsets of archive indices.
+	if off < 0 || off+int64(len(p)) > math.MaxUint32 {
+		return 0, errors.WithStack(fmt.Errorf("invalid range %d-%d", off, off+int64(len(p))))
+	}
+	rc, err := r.c.download(common.PathTypeData, r.hash, false, uint32(off), uint32(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(rc, p)
+	if cerr := rc.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		// io.ReaderAt callers compare the error to io.EOF.
+		return n, io.EOF
+	}
+	return n, errors.WithStack(err)
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+// contentLength requests the size of the file of type pathType named after hash.
+func (c *cdnClient) contentLength(pathType string, hash []byte) (int64, error) {
+	url, err := common.Url(c.host, c.path, pathType, hash, false)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.client.Head(url)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 || resp.ContentLength < 0 {
+		return 0, errors.WithStack(fmt.Errorf("(%d) %s ", resp.StatusCode, url))
+	}
+	return resp.ContentLength, nil
+}
+
+// download requests the file of type pathType named after hash.
+// Only size bytes starting at offset are requested if size is not zero, in which case
+// the response must be partial: a server ignoring the range would return the whole file.
+// The caller must close the returned response body.
+func (c *cdnClient) download(pathType string, hash []byte, index bool, offset, size uint32) (rc io.ReadCloser, err error) {
+	url, err := common.Url(c.host, c.path, pathType, hash, index)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	return decodeBlteFn(bytes.NewReader(b))
+	if size > 0 {
+		// The range end is inclusive.
+		req.Header.Add("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+size-1))
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 || (size > 0 && resp.StatusCode != http.StatusPartialContent) {
+		if cerr := resp.Body.Close(); cerr != nil {
+			return nil, errors.WithStack(cerr)
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, errors.Wrapf(ErrNotFound, "(%d) %s", resp.StatusCode, url)
+		}
+		return nil, errors.WithStack(fmt.Errorf("(%d) %s ", resp.StatusCode, url))
+	}
+	return resp.Body, nil
+}
+
+// cachePath returns the path of the cached file of type pathType named after hash.
+// An empty path is returned if the cache is disabled.
+func (c *cdnClient) cachePath(pathType string, hash []byte, index bool) (string, error) {
+	if c.cacheDir == "" {
+		return "", nil
+	}
+	return cdnFilePath(c.cacheDir, pathType, hash, index)
+}
+
+// writeCache atomically writes the content of r to the file named filename.
+// If size is not zero, nothing is written unless the content is size bytes long.
+// Concurrent writes of the same file are safe since the content is always the same.
+func writeCache(filename string, r io.Reader, size int64) (err error) {
+	dir := filepath.Dir(filename)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	tmp, err := ioutil.TempFile(dir, filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return errors.WithStack(err)
+	}
+	if size != 0 && n != size {
+		tmp.Close()
+		return errors.WithStack(fmt.Errorf("expected %d bytes got %d", size, n))
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
 }
@@ -0,0 +1,384 @@
+package casc
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCdnClientCache(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch r.URL.Path {
+		case "/tpr/test/config/01/23/0123":
+			w.Write([]byte("config"))
+		case "/tpr/test/data/ab/cd/abcd":
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader("0123456789"))
+		case "/tpr/test/data/12/34/1234":
+			w.Write([]byte("0123456789")) // ignores the range
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cacheDir, err := ioutil.TempDir("", "casc-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	cdn := &cdnClient{
+		client:   server.Client(),
+		host:     strings.TrimPrefix(server.URL, "http://"),
+		path:     "tpr/test",
+		cacheDir: cacheDir,
+	}
+	for i := 0; i < 2; i++ {
+		b, err := cdn.get("config", []byte{0x01, 0x23}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "config" {
+			t.Fatalf("unexpected config %q", b)
+		}
+		b, err = readAll(cdn.openData("data", []byte{0xee, 0xff}, []byte{0xab, 0xcd}, 2, 3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "234" {
+			t.Fatalf("unexpected range %q", b)
+		}
+	}
+	if requests != 2 {
+		t.Fatalf("expected 2 requests got %d", requests)
+	}
+	cached, err := ioutil.ReadFile(filepath.Join(cacheDir, "data", "ee", "ff", "eeff"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cached, []byte("234")) {
+		t.Fatalf("unexpected cached range %q", cached)
+	}
+	if _, err := cdn.get("config", []byte{0x45, 0x67}, false); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound got %+v", err)
+	}
+	if _, err := readAll(cdn.openData("data", []byte{0x56, 0x78}, []byte{0x12, 0x34}, 2, 3)); err == nil {
+		t.Fatal("expected an error when the range is ignored")
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, "data", "56", "78", "5678")); !os.IsNotExist(err) {
+		t.Fatalf("unexpected cached file %v", err)
+	}
+}
+
+func TestRangeReaderAt(t *testing.T) {
+	ignoreRange := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ignoreRange {
+			w.Write([]byte("0123456789"))
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("0123456789"))
+	}))
+	defer server.Close()
+	cdn := &cdnClient{client: server.Client(), host: strings.TrimPrefix(server.URL, "http://"), path: "tpr/test"}
+	ra := &rangeReaderAt{cdn, []byte{0xab, 0xcd}}
+
+	p := make([]byte, 4)
+	if n, err := ra.ReadAt(p, 2); err != nil || string(p[:n]) != "2345" {
+		t.Fatalf("unexpected read %q %+v", p[:n], err)
+	}
+	if n, err := ra.ReadAt(p, 8); err != io.EOF || string(p[:n]) != "89" {
+		t.Fatalf("expected io.EOF got %q %+v", p[:n], err)
+	}
+	if _, err := ra.ReadAt(p, math.MaxUint32); err == nil {
+		t.Fatal("expected an out of range error")
+	}
+	ignoreRange = true
+	if _, err := ra.ReadAt(p, 2); err == nil {
+		t.Fatal("expected an error when the range is ignored")
+	}
+}
+
+// testTransport sends all requests to the host of target.
+type testTransport struct {
+	target *url.URL
+}
+
+func (tr testTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = tr.target.Scheme
+	r.URL.Host = tr.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestOnlineWithBuild(t *testing.T) {
+	files := map[string]string{"War3.mpq:Units/Human/Footman.blp": "footman"}
+	cdn, close := newTestCDN(t, files)
+	defer close()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/w3/versions", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("versions must not be requested when the build is pinned")
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/w3/cdns", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Name!STRING:0|Path!STRING:0|Hosts!STRING:0\nus|tpr/test|cdn.test\n"))
+	})
+	mux.Handle("/tpr/test/", http.StripPrefix("/tpr/test/", http.FileServer(http.Dir(cdn.dir))))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	target, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: testTransport{target}}
+
+	explorer, err := Online(Warcraft3, RegionUS, RegionUS, client, WithBuild(cdn.buildConfigHash, cdn.cdnConfigHash))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if explorer.Version() != "1.0.0.1" {
+		t.Fatalf("unexpected version %s", explorer.Version())
+	}
+	b, err := explorer.Extract("War3.mpq:Units/Human/Footman.blp")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if string(b) != "footman" {
+		t.Fatalf("unexpected content %q", b)
+	}
+	if _, err := Online(Warcraft3, RegionUS, RegionUS, client, WithBuild(cdn.buildConfigHash, "")); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestOnlineReadAt(t *testing.T) {
+	content := strings.Repeat("0123456789abcdef", 64)
+	files := map[string]string{"War3.mpq:Units/Human/Footman.blp": content}
+	cdn, close := newTestCDN(t, files)
+	defer close()
+	var ranges []string
+	fileServer := http.FileServer(http.Dir(cdn.dir))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/w3/cdns", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Name!STRING:0|Path!STRING:0|Hosts!STRING:0\nus|tpr/test|cdn.test\n"))
+	})
+	mux.Handle("/tpr/test/", http.StripPrefix("/tpr/test/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		fileServer.ServeHTTP(w, r)
+	})))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	target, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: testTransport{target}}
+	explorer, err := Online(Warcraft3, RegionUS, RegionUS, client, WithBuild(cdn.buildConfigHash, cdn.cdnConfigHash))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	ranges = nil
+	b, err := explorer.ReadAt("War3.mpq:Units/Human/Footman.blp", 40, 20)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if string(b) != content[40:60] {
+		t.Fatalf("unexpected content %q", b)
+	}
+	// BLTE header size, BLTE header and the two chunks of 16 bytes covering the range.
+	if len(ranges) != 4 {
+		t.Fatalf("unexpected requests %v", ranges)
+	}
+	for _, r := range ranges {
+		if r == "" {
+			t.Fatalf("unexpected request without range %v", ranges)
+		}
+	}
+
+	b, err = explorer.ReadAt("War3.mpq:Units/Human/Footman.blp", int64(len(content)-4), 20)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if string(b) != "cdef" {
+		t.Fatalf("unexpected content %q", b)
+	}
+}
+
+// recordingCDN records the archives files are opened from.
+type recordingCDN struct {
+	cdnSource
+	mu       sync.Mutex
+	archives map[string]string
+}
+
+func (r *recordingCDN) openData(pathType string, key, archiveHash []byte, offset, size uint32) (io.ReadCloser, error) {
+	r.mu.Lock()
+	r.archives[hex.EncodeToString(key)] = hex.EncodeToString(archiveHash)
+	r.mu.Unlock()
+	return r.cdnSource.openData(pathType, key, archiveHash, offset, size)
+}
+
+func TestOnlineArchiveIndex(t *testing.T) {
+	files := map[string]string{
+		"War3.mpq:Units/Human/Footman.blp": "footman",
+		"War3.mpq:Units/Orc/Grunt.blp":     "grunt",
+	}
+	cdn, close := newTestCDN(t, files)
+	defer close()
+	buildConfigHash := mustDecodeHex(t, cdn.buildConfigHash)
+	cdnConfigHash := mustDecodeHex(t, cdn.cdnConfigHash)
+	rec := &recordingCDN{cdnSource: &mirrorCDN{cdn.dir}, archives: map[string]string{}}
+	s, err := newCDNStorage(Warcraft3, "", buildConfigHash, cdnConfigHash, rec, newOptions(nil))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(s.archivesIndices) != len(files) {
+		t.Fatalf("expected %d archive indices got %d", len(files), len(s.archivesIndices))
+	}
+
+	// Files are read from the archive.
+	for _, content := range files {
+		ckey := md5.Sum([]byte(content))
+		ekeys, ok := s.encoding.EncodedHashes(ckey[:])
+		if !ok {
+			t.Fatalf("%s: missing encoding entry", content)
+		}
+		idx, ok := s.archiveIndex(ekeys[0])
+		if !ok {
+			t.Fatalf("%s: expected an archive entry", content)
+		}
+		b, err := s.FromContentHash(ckey[:])
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if string(b) != content {
+			t.Fatalf("expected %q got %q", content, b)
+		}
+		if archive := rec.archives[hex.EncodeToString(ekeys[0])]; archive != hex.EncodeToString(idx.archiveHash) || archive == hex.EncodeToString(ekeys[0]) {
+			t.Fatalf("%s: unexpected archive %s", content, archive)
+		}
+	}
+
+	// The root is not within an archive and is read from its loose data file.
+	rootEKeys, ok := s.encoding.EncodedHashes(s.RootHash())
+	if !ok {
+		t.Fatal("missing root encoding entry")
+	}
+	if _, ok := s.archiveIndex(rootEKeys[0]); ok {
+		t.Fatal("unexpected root archive entry")
+	}
+	if _, err := s.FromContentHash(s.RootHash()); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if archive := rec.archives[hex.EncodeToString(rootEKeys[0])]; archive != hex.EncodeToString(rootEKeys[0]) {
+		t.Fatalf("expected the loose root got archive %s", archive)
+	}
+
+	if _, ok := s.archiveIndex(make([]byte, 0x10)); ok {
+		t.Fatal("unexpected archive entry")
+	}
+	if _, ok := s.archiveIndex(rootEKeys[0][:9]); ok {
+		t.Fatal("unexpected archive entry for a truncated key")
+	}
+	if _, err := s.OpenEncodedHash(make([]byte, 0x10)); err == nil {
+		t.Fatal("expected an error for a missing loose file")
+	}
+}
+
+func TestOnlinePatchArchives(t *testing.T) {
+	cdn, close := newTestCDN(t, map[string]string{"War3.mpq:Units/Human/Footman.blp": "footman"})
+	defer close()
+	write := func(pathType string, hash []byte, index bool, b []byte) {
+		filename, err := cdnFilePath(cdn.dir, pathType, hash, index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, b, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A patch archive containing two patches and a loose patch.
+	patches := [][]byte{[]byte("first patch"), []byte("second patch")}
+	archive := bytes.NewBuffer(nil)
+	var index []byte
+	for _, patch := range patches {
+		entry := make([]byte, 0x18)
+		hash := md5.Sum(patch)
+		copy(entry, hash[:])
+		binary.BigEndian.PutUint32(entry[0x10:], uint32(len(patch)))
+		binary.BigEndian.PutUint32(entry[0x14:], uint32(archive.Len()))
+		index = append(index, entry...)
+		archive.Write(patch)
+	}
+	footer := make([]byte, 12)
+	binary.LittleEndian.PutUint32(footer, uint32(len(patches)))
+	index = append(index, footer...)
+	archiveHash := md5.Sum(index)
+	write("patch", archiveHash[:], false, archive.Bytes())
+	write("patch", archiveHash[:], true, index)
+	loose := []byte("loose patch")
+	looseHash := md5.Sum(loose)
+	write("patch", looseHash[:], false, loose)
+
+	cdnConfigPath, err := cdnFilePath(cdn.dir, "config", mustDecodeHex(t, cdn.cdnConfigHash), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cdnConfig, err := ioutil.ReadFile(cdnConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cdnConfig = append(cdnConfig, fmt.Sprintf("patch-archives = %x\n", archiveHash)...)
+	cdnConfigHash := md5.Sum(cdnConfig)
+	write("config", cdnConfigHash[:], false, cdnConfig)
+
+	s, err := newCDNStorage(Warcraft3, "", mustDecodeHex(t, cdn.buildConfigHash), cdnConfigHash[:], &mirrorCDN{cdn.dir}, newOptions(nil))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for _, patch := range append(patches, loose) {
+		hash := md5.Sum(patch)
+		b, err := s.Patch(hash[:])
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if !bytes.Equal(b, patch) {
+			t.Fatalf("expected %q got %q", patch, b)
+		}
+	}
+	if _, err := s.Patch(make([]byte, 0x10)); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound got %+v", err)
+	}
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
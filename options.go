@@ -0,0 +1,140 @@
+package casc
+
+import "github.com/jybp/casc/blte"
+
+// Option configures how an Explorer accesses CASC files.
+type Option func(*options)
+
+type options struct {
+	cacheDir        string
+	buildConfigHash string
+	cdnConfigHash   string
+	product         string
+	branch          string
+	active          bool
+	app             string
+	keys            blte.Keys
+	zeroFill        bool
+	locale          string
+	platform        string
+	listfile        map[uint32]string
+}
+
+func newOptions(opts []Option) options {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// blteOptions returns the options used to decode BLTE data.
+func (o options) blteOptions() []blte.Option {
+	var opts []blte.Option
+	if o.keys != nil {
+		opts = append(opts, blte.WithKeys(o.keys))
+	}
+	if o.zeroFill {
+		opts = append(opts, blte.WithZeroFill())
+	}
+	return opts
+}
+
+// WithCacheDir makes Online store the files downloaded from the CDN under dir.
+// Files are laid out like the CDN (config/xx/yy/hash, data/xx/yy/hash.index, data/xx/yy/hash)
+// and are read from dir instead of being downloaded again.
+// Data located within archives is stored under the encoded hash of the content.
+// All files are named after their hash so the cache never needs to be invalidated.
+func WithCacheDir(dir string) Option {
+	return func(o *options) {
+		o.cacheDir = dir
+	}
+}
+
+// WithBuild makes Online open the build identified by the hex encoded hashes
+// of its build config and CDN config instead of the build currently listed for the region.
+// Old builds can be opened as long as their files are still available on the CDN.
+func WithBuild(buildConfigHash, cdnConfigHash string) Option {
+	return func(o *options) {
+		o.buildConfigHash = buildConfigHash
+		o.cdnConfigHash = cdnConfigHash
+	}
+}
+
+// WithProduct makes Local use the .build.info entry with the product code product (i.e. w3 or w3t).
+func WithProduct(product string) Option {
+	return func(o *options) {
+		o.product = product
+	}
+}
+
+// WithBranch makes Local use the .build.info entry of branch (i.e. us or eu).
+func WithBranch(branch string) Option {
+	return func(o *options) {
+		o.branch = branch
+	}
+}
+
+// WithActive makes Local use an active .build.info entry.
+func WithActive() Option {
+	return func(o *options) {
+		o.active = true
+	}
+}
+
+// WithApp makes Local use the program code app instead of detecting it
+// from the .build.info file and the build config.
+func WithApp(app string) Option {
+	return func(o *options) {
+		o.app = app
+	}
+}
+
+// WithKeys makes the Explorer decrypt encrypted files using keys.
+// Reading a file encrypted with a missing key returns a *blte.MissingKeyError.
+// Use blte.ParseKeys to load keys from a "keyname keyvalue" text file.
+func WithKeys(keys blte.Keys) Option {
+	return func(o *options) {
+		o.keys = keys
+	}
+}
+
+// WithZeroFill makes the Explorer return zeros in place of the content encrypted
+// with a missing key instead of a *blte.MissingKeyError. See blte.WithZeroFill.
+func WithZeroFill() Option {
+	return func(o *options) {
+		o.zeroFill = true
+	}
+}
+
+// WithLocale makes the Explorer expose the files of locale (i.e. enUS or frFR) by their logical filename.
+// For example, the Warcraft III file "enUS-War3Local.mpq:UI/Foo.fdf" is exposed as "War3Local.mpq:UI/Foo.fdf",
+// the StarCraft file "locales/enUS/Assets/SD/Foo.webm" as "SD/Foo.webm" and the Diablo III file
+// "enUS_Text/Foo.stl" as "Foo.stl". The localized variant of a file takes precedence over the
+// variant that is not localized and the files of other locales are not exposed.
+// For World of Warcraft, only the files of the root blocks of locale are exposed.
+// Logical filenames are case insensitive. An error is returned if locale is not a locale code.
+// Use Explorer.Locales to list the locales a file exists in.
+func WithLocale(locale string) Option {
+	return func(o *options) {
+		o.locale = locale
+	}
+}
+
+// WithPlatform makes the Explorer expose the files of platform by their logical filename.
+// Only Diablo III has platform specific files, within its "Windows" and "Mac" root directories.
+// See WithLocale.
+func WithPlatform(platform string) Option {
+	return func(o *options) {
+		o.platform = platform
+	}
+}
+
+// WithListfile makes the World of Warcraft Explorer name files using listfile,
+// which relates FileDataIDs to filenames. Use wow.ParseListfile to parse a community listfile.
+// Files that are not named are listed as "unknown/<FileDataID>".
+func WithListfile(listfile map[uint32]string) Option {
+	return func(o *options) {
+		o.listfile = listfile
+	}
+}
@@ -0,0 +1,127 @@
+package casc
+
+import (
+	"bytes"
+	"crypto/md5"
+	"io/ioutil"
+
+	"github.com/jybp/casc/blte"
+	"github.com/jybp/casc/common"
+	"github.com/jybp/casc/zbsdiff"
+	"github.com/pkg/errors"
+)
+
+// ErrNoPatch is the error returned by Patcher.Extract if no patch can produce the file
+// from the files of the old build. The file must be extracted as a whole instead.
+var ErrNoPatch = errors.New("no patch")
+
+// Patcher extracts the files of a build by patching the files of an older build
+// instead of downloading whole files.
+// A Patcher is safe for concurrent use by multiple goroutines.
+type Patcher struct {
+	explorer Explorer
+	old      Explorer
+	manifest *common.PatchManifest
+	config   *common.PatchConfig
+}
+
+// NewPatcher creates a Patcher extracting the files of explorer by patching the files of old.
+// explorer must read from the CDN (Online or Mirror) and old is usually a Local installation.
+// Patches are fetched from the patch archives of the CDN config or as loose files.
+// The patch manifest is referenced by the build config or by its patch config.
+func NewPatcher(explorer, old *Explorer) (*Patcher, error) {
+	config, err := explorer.storage.PatchConfig()
+	if err != nil {
+		return nil, err
+	}
+	patchHash := explorer.storage.PatchHash()
+	if len(patchHash) == 0 && config != nil {
+		patchHash = config.PatchHash
+	}
+	if len(patchHash) == 0 {
+		return nil, errors.WithStack(errors.New("no patch manifest"))
+	}
+	b, err := explorer.storage.Patch(patchHash)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := common.ParsePatchManifest(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	return &Patcher{explorer: *explorer, old: *old, manifest: manifest, config: config}, nil
+}
+
+// Manifest returns the patch manifest.
+func (p *Patcher) Manifest() *common.PatchManifest {
+	return p.manifest
+}
+
+// Extract extracts the file with the given filename by patching a file of the old build.
+// Returns the error of the root if the file was not found and casc.ErrNoPatch
+// if the file cannot be produced from a file of the old build.
+func (p *Patcher) Extract(filename string) ([]byte, error) {
+	contentHash, err := p.explorer.root.ContentHash(filename)
+	if err != nil {
+		return nil, err
+	}
+	return p.ExtractContentHash(contentHash)
+}
+
+// ExtractContentHash extracts the content contentHash by patching a file of the old build.
+// The patches of the build files (i.e. the encoding file) are listed by the patch config.
+// Records whose old file or patch is missing are skipped.
+// Returns casc.ErrNoPatch if the content cannot be produced from a file of the old build.
+func (p *Patcher) ExtractContentHash(contentHash []byte) ([]byte, error) {
+	for _, record := range p.records(contentHash) {
+		old, err := readAll(p.old.storage.OpenEncodedHash(record.OldEncodedHash))
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		patch, err := p.explorer.storage.Patch(record.PatchHash)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Patch files may be BLTE encoded.
+		if bytes.HasPrefix(patch, []byte("BLTE")) {
+			r, err := blte.NewReader(bytes.NewReader(patch))
+			if err != nil {
+				return nil, err
+			}
+			if patch, err = ioutil.ReadAll(r); err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+		b, err := zbsdiff.Apply(old, patch)
+		if err != nil {
+			return nil, err
+		}
+		if hash := md5.Sum(b); len(contentHash) == len(hash) && !bytes.Equal(hash[:], contentHash) {
+			return nil, errors.WithStack(errors.Errorf("patched content hash %x expected %x", hash, contentHash))
+		}
+		return b, nil
+	}
+	return nil, ErrNoPatch
+}
+
+// records returns the patches producing contentHash from the patch manifest and the patch config.
+func (p *Patcher) records(contentHash []byte) []common.PatchRecord {
+	var records []common.PatchRecord
+	if entry, ok := p.manifest.Entry(contentHash); ok {
+		records = append(records, entry.Patches...)
+	}
+	if p.config != nil {
+		for _, entry := range p.config.Entries {
+			if bytes.Equal(entry.ContentHash, contentHash) {
+				records = append(records, entry.Patches...)
+			}
+		}
+	}
+	return records
+}
@@ -0,0 +1,209 @@
+package casc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"encoding/binary"
+	"testing"
+
+	"github.com/jybp/casc/common"
+)
+
+// testZBSDIFF creates a ZBSDIFF1 patch made of a single control entry.
+func testZBSDIFF(diff, extra []byte, seek int64) []byte {
+	deflate := func(b []byte) []byte {
+		buf := bytes.NewBuffer(nil)
+		w := zlib.NewWriter(buf)
+		w.Write(b)
+		w.Close()
+		return buf.Bytes()
+	}
+	ctrl := bytes.NewBuffer(nil)
+	binary.Write(ctrl, binary.BigEndian, []int64{int64(len(diff)), int64(len(extra)), seek})
+	ctrlZ, diffZ, extraZ := deflate(ctrl.Bytes()), deflate(diff), deflate(extra)
+	b := bytes.NewBufferString("ZBSDIFF1")
+	binary.Write(b, binary.BigEndian, []int64{int64(len(ctrlZ)), int64(len(diffZ)), int64(len(diff) + len(extra))})
+	b.Write(ctrlZ)
+	b.Write(diffZ)
+	b.Write(extraZ)
+	return b.Bytes()
+}
+
+// testPatchManifest creates a patch manifest with a single block
+// where each entry has a single patch.
+func testPatchManifest(entries []testPatchEntry) []byte {
+	block := make([]byte, 1024)
+	offset := 0
+	for _, e := range entries {
+		block[offset] = 1
+		offset++
+		offset += copy(block[offset:], e.ckey[:])
+		binary.BigEndian.PutUint32(block[offset+1:], e.size)
+		offset += 5
+		offset += copy(block[offset:], e.oldEKey[:])
+		binary.BigEndian.PutUint32(block[offset+1:], e.oldSize)
+		offset += 5
+		offset += copy(block[offset:], e.patchKey[:])
+		binary.BigEndian.PutUint32(block[offset:], e.patchSize)
+		offset += 4
+		block[offset] = 1 // ordinal
+		offset++
+	}
+	checksum := md5.Sum(block)
+	b := bytes.NewBuffer(nil)
+	b.Write([]byte{'P', 'A', 2, 0x10, 0x10, 0x10, 10, 0, 1, 0})
+	last := entries[len(entries)-1].ckey
+	b.Write(last[:])
+	b.Write(checksum[:])
+	binary.Write(b, binary.BigEndian, uint32(b.Len()+4))
+	b.Write(block)
+	return b.Bytes()
+}
+
+type testPatchEntry struct {
+	ckey, oldEKey, patchKey  [0x10]byte
+	size, oldSize, patchSize uint32
+}
+
+func TestPatcher(t *testing.T) {
+	oldContent, newContent := "hello, world", "jello!"
+	old := testExplorer(map[string]string{"a.txt": oldContent})
+	explorer := testExplorer(map[string]string{
+		"a.txt": newContent,
+		"b.txt": "not patched",
+		"c.txt": "patched from a missing file",
+		"e.txt": "jello?",
+	})
+
+	patch := testZBSDIFF([]byte{2, 0, 0, 0, 0}, []byte("!"), 7)
+	manifest := testPatchManifest([]testPatchEntry{
+		{
+			ckey:      md5.Sum([]byte(newContent)),
+			oldEKey:   md5.Sum([]byte(oldContent)),
+			patchKey:  md5.Sum(patch),
+			size:      uint32(len(newContent)),
+			oldSize:   uint32(len(oldContent)),
+			patchSize: uint32(len(patch)),
+		},
+		{
+			// The patch is missing.
+			ckey:      md5.Sum([]byte("jello?")),
+			oldEKey:   md5.Sum([]byte(oldContent)),
+			patchKey:  md5.Sum(testZBSDIFF([]byte{2, 0, 0, 0, 0}, []byte("?"), 7)),
+			size:      uint32(len("jello?")),
+			oldSize:   uint32(len(oldContent)),
+			patchSize: uint32(len(patch)),
+		},
+		{
+			ckey:      md5.Sum([]byte("patched from a missing file")),
+			oldEKey:   md5.Sum([]byte("missing")),
+			patchKey:  md5.Sum(patch),
+			patchSize: uint32(len(patch)),
+		},
+	})
+	storage := explorer.storage.(*testStorage)
+	manifestHash := md5.Sum(manifest)
+	storage.patchHash = manifestHash[:]
+	storage.patches = map[[0x10]byte][]byte{
+		manifestHash:   manifest,
+		md5.Sum(patch): patch,
+	}
+
+	patcher, err := NewPatcher(explorer, old)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	b, err := patcher.Extract("a.txt")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if string(b) != newContent {
+		t.Fatalf("expected %q got %q", newContent, b)
+	}
+	for _, filename := range []string{"b.txt", "c.txt", "e.txt"} {
+		if _, err := patcher.Extract(filename); err != ErrNoPatch {
+			t.Fatalf("%s: expected ErrNoPatch got %+v", filename, err)
+		}
+	}
+	if _, err := patcher.Extract("d.txt"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound got %+v", err)
+	}
+	if _, err := NewPatcher(old, old); err == nil {
+		t.Fatal("expected no patch manifest error")
+	}
+}
+
+func TestPatcherConfig(t *testing.T) {
+	oldContent, newContent := "hello, world", "jello!"
+	old := testExplorer(map[string]string{"a.txt": oldContent})
+	explorer := testExplorer(map[string]string{"a.txt": newContent})
+
+	// The manifest only references b.txt, a.txt is patched using the patch config.
+	patch := testZBSDIFF([]byte{2, 0, 0, 0, 0}, []byte("!"), 7)
+	manifest := testPatchManifest([]testPatchEntry{{ckey: md5.Sum([]byte("b"))}})
+	manifestHash, newHash, oldHash, patchHash := md5.Sum(manifest), md5.Sum([]byte(newContent)), md5.Sum([]byte(oldContent)), md5.Sum(patch)
+	storage := explorer.storage.(*testStorage)
+	storage.patches = map[[0x10]byte][]byte{
+		manifestHash: manifest,
+		patchHash:    patch,
+	}
+	storage.patchConfig = &common.PatchConfig{
+		PatchHash: manifestHash[:],
+		Entries: []common.PatchConfigEntry{{
+			Type:        "encoding",
+			ContentHash: newHash[:],
+			Patches: []common.PatchRecord{
+				{OldEncodedHash: make([]byte, 0x10), PatchHash: patchHash[:]}, // missing old file
+				{OldEncodedHash: oldHash[:], PatchHash: patchHash[:]},
+			},
+		}},
+	}
+
+	patcher, err := NewPatcher(explorer, old)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	b, err := patcher.ExtractContentHash(newHash[:])
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if string(b) != newContent {
+		t.Fatalf("expected %q got %q", newContent, b)
+	}
+}
+
+func TestPatcherLocalMissingBucket(t *testing.T) {
+	oldContent, newContent := "hello, world", "jello!"
+	installDir, close := newTestLocal(t, map[string]string{"War3.mpq:a.txt": oldContent})
+	defer close()
+	old, err := Local(installDir)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	// The bucket of the old file has no .idx file.
+	old.storage.(*local).idxs = map[uint8]*idxBucket{}
+
+	explorer := testExplorer(map[string]string{"a.txt": newContent})
+	patch := testZBSDIFF([]byte{2, 0, 0, 0, 0}, []byte("!"), 7)
+	manifest := testPatchManifest([]testPatchEntry{{
+		ckey:      md5.Sum([]byte(newContent)),
+		oldEKey:   md5.Sum(testBLTE([]byte(oldContent))),
+		patchKey:  md5.Sum(patch),
+		size:      uint32(len(newContent)),
+		oldSize:   uint32(len(oldContent)),
+		patchSize: uint32(len(patch)),
+	}})
+	storage := explorer.storage.(*testStorage)
+	manifestHash := md5.Sum(manifest)
+	storage.patchHash = manifestHash[:]
+	storage.patches = map[[0x10]byte][]byte{manifestHash: manifest, md5.Sum(patch): patch}
+
+	patcher, err := NewPatcher(explorer, old)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, err := patcher.Extract("a.txt"); err != ErrNoPatch {
+		t.Fatalf("expected ErrNoPatch got %+v", err)
+	}
+}
@@ -141,7 +141,50 @@ func (r *Root) ContentHash(filename string) ([]byte, error) {
 	return contentHash[:], nil
 }
 
+// Platforms are the root directories containing platform specific files.
+var Platforms = []string{"Windows", "Mac"}
+
+// dirVariant returns the locale or the platform the root directory dir is specific to.
+// Localized directories are named after their locale, optionally followed by '_' (i.e. "enUS_Text").
+func dirVariant(dir string) (locale, platform string) {
+	if len(dir) >= 4 && common.IsLocale(dir[:4]) && (len(dir) == 4 || dir[4] == '_') {
+		return dir[:4], ""
+	}
+	for _, p := range Platforms {
+		if dir == p {
+			return "", p
+		}
+	}
+	return "", ""
+}
+
+// Variant returns the logical filename of filename and the locale or the platform it is specific to.
+// The logical filename is the filename without its root directory: "enUS_Text/Foo.stl",
+// "Windows/Foo.stl" and "Base/Foo.stl" are variants of "Foo.stl".
+func (r *Root) Variant(filename string) (logical, locale, platform string) {
+	i := strings.Index(filename, "/")
+	if i < 0 {
+		return filename, "", ""
+	}
+	dir := filename[:i]
+	locale, platform = dirVariant(dir)
+	if locale == "" && platform == "" && dir != "Base" {
+		return filename, "", ""
+	}
+	return filename[i+1:], locale, platform
+}
+
+// NewRoot parses all root directories that can be fetched.
+// Directories that cannot be fetched are skipped: 'Mac' and 'Windows' cannot be fetched locally
+// and the directory of a language is not referenced in the .idx if the language is not installed.
 func NewRoot(root []byte, fetchFn func(contentHash []byte) ([]byte, error)) (*Root, error) {
+	return NewLocalizedRoot(root, fetchFn, "", "")
+}
+
+// NewLocalizedRoot parses all root directories that can be fetched like NewRoot
+// but returns an error if a directory of locale or platform cannot be fetched.
+// The files of all locales and platforms are parsed so that the locales of a file can be listed.
+func NewLocalizedRoot(root []byte, fetchFn func(contentHash []byte) ([]byte, error), locale, platform string) (*Root, error) {
 	dirEntries, err := parseRoot(bytes.NewReader(root))
 	if err != nil {
 		return nil, err
@@ -150,10 +193,13 @@ func NewRoot(root []byte, fetchFn func(contentHash []byte) ([]byte, error)) (*Ro
 	assetIdxEntriesByDir := map[string][]AssetIdxEntry{}
 	namedEntriesByDir := map[string][]NamedEntry{}
 	for _, dirEntry := range dirEntries {
+		dirLocale, dirPlatform := dirVariant(dirEntry.Filename)
+		required := (locale != "" && dirLocale == locale) || (platform != "" && dirPlatform == platform)
 		dirB, err := fetchFn(dirEntry.ContentHash[:])
 		if err != nil {
-			// 'Mac' and 'Windows' dirEntry cannot be locally fetch.
-			// Each language has a dirEntry that won't be referenced in the .idx if not installed.
+			if required {
+				return nil, errors.Wrapf(err, "%s", dirEntry.Filename)
+			}
 			continue
 		}
 		assets, assetIdxs, nameds, err := parseRootDirectory(bytes.NewReader(dirB))
@@ -33,6 +33,17 @@ func (r *Root) ContentHash(filename string) ([]byte, error) {
 	return contentHash[:], nil
 }
 
+// Variant returns the logical filename of filename and the locale it is specific to.
+// Localized files are stored under "locales/<locale>/": "locales/enUS/Assets/SD/glue/foo.webm"
+// is the enUS variant of "SD/glue/foo.webm". Files are not platform specific.
+func (r *Root) Variant(filename string) (logical, locale, platform string) {
+	parts := strings.SplitN(filename, "/", 3)
+	if len(parts) != 3 || parts[0] != "locales" || !common.IsLocale(parts[1]) {
+		return filename, "", ""
+	}
+	return strings.TrimPrefix(parts[2], "Assets/"), parts[1], ""
+}
+
 func NewRoot(root []byte) (*Root, error) {
 	nameToContentHash := map[string][]byte{}
 	scanner := bufio.NewScanner(bytes.NewReader(root))
@@ -34,6 +34,16 @@ func (r *Root) ContentHash(filename string) ([]byte, error) {
 	return contentHash[:], nil
 }
 
+// Variant returns the logical filename of filename and the locale it is specific to.
+// Localized files are prefixed by their locale: "enUS-War3Local.mpq:UI/Foo.fdf" is
+// the enUS variant of "War3Local.mpq:UI/Foo.fdf". Files are not platform specific.
+func (r *Root) Variant(filename string) (logical, locale, platform string) {
+	if i := strings.Index(filename, "-"); i == 4 && common.IsLocale(filename[:i]) {
+		return filename[i+1:], filename[:i], ""
+	}
+	return filename, "", ""
+}
+
 func NewRoot(root []byte) (*Root, error) {
 	nameToContentHash := map[string][]byte{}
 	scanner := bufio.NewScanner(bytes.NewReader(root))
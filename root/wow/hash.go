@@ -0,0 +1,85 @@
+package wow
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+// NameHash returns the hash of filename used by roots to identify named files.
+// The hash is the Jenkins hashlittle2 of the uppercased filename whose '/' are replaced by '\'.
+func NameHash(filename string) uint64 {
+	name := strings.ToUpper(strings.Replace(filename, "/", "\\", -1))
+	pc, pb := hashlittle2([]byte(name), 0, 0)
+	return uint64(pc)<<32 | uint64(pb)
+}
+
+// hashlittle2 is the hashlittle2 function of Bob Jenkins' lookup3.c.
+// It returns the primary hash pc and the secondary hash pb of key.
+func hashlittle2(key []byte, pc, pb uint32) (uint32, uint32) {
+	length := len(key)
+	a := 0xdeadbeef + uint32(length) + pc
+	b, c := a, a
+	c += pb
+	for ; length > 12; length -= 12 {
+		a += binary.LittleEndian.Uint32(key[0:])
+		b += binary.LittleEndian.Uint32(key[4:])
+		c += binary.LittleEndian.Uint32(key[8:])
+		a, b, c = mix(a, b, c)
+		key = key[12:]
+	}
+	if length == 0 {
+		return c, b
+	}
+	// The last block is zero padded.
+	var tail [12]byte
+	copy(tail[:], key)
+	a += binary.LittleEndian.Uint32(tail[0:])
+	b += binary.LittleEndian.Uint32(tail[4:])
+	c += binary.LittleEndian.Uint32(tail[8:])
+	_, b, c = final(a, b, c)
+	return c, b
+}
+
+func rot(x uint32, k uint) uint32 {
+	return x<<k | x>>(32-k)
+}
+
+func mix(a, b, c uint32) (uint32, uint32, uint32) {
+	a -= c
+	a ^= rot(c, 4)
+	c += b
+	b -= a
+	b ^= rot(a, 6)
+	a += c
+	c -= b
+	c ^= rot(b, 8)
+	b += a
+	a -= c
+	a ^= rot(c, 16)
+	c += b
+	b -= a
+	b ^= rot(a, 19)
+	a += c
+	c -= b
+	c ^= rot(b, 4)
+	b += a
+	return a, b, c
+}
+
+func final(a, b, c uint32) (uint32, uint32, uint32) {
+	c ^= b
+	c -= rot(b, 14)
+	a ^= c
+	a -= rot(c, 11)
+	b ^= a
+	b -= rot(a, 25)
+	c ^= b
+	c -= rot(b, 16)
+	a ^= c
+	a -= rot(c, 4)
+	b ^= a
+	b -= rot(a, 14)
+	c ^= b
+	c -= rot(b, 24)
+	return a, b, c
+}
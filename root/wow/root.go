@@ -0,0 +1,353 @@
+// Package wow parses the root of World of Warcraft (program code "wow").
+//
+// The root relates FileDataIDs to content hashes. It is made of blocks of files sharing
+// locale and content flags. Files are named by a community listfile relating FileDataIDs
+// to filenames (see ParseListfile) or by the hash of their name (see NameHash).
+// Both the legacy root and the MFST root introduced by 8.2 are supported.
+package wow
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jybp/casc/common"
+	"github.com/pkg/errors"
+)
+
+// LocaleFlags relates locales to the locale flags of the root blocks.
+var LocaleFlags = map[string]uint32{
+	"enUS": 0x2,
+	"koKR": 0x4,
+	"frFR": 0x10,
+	"deDE": 0x20,
+	"zhCN": 0x40,
+	"esES": 0x80,
+	"zhTW": 0x100,
+	"enGB": 0x200,
+	"enCN": 0x400,
+	"enTW": 0x800,
+	"esMX": 0x1000,
+	"ruRU": 0x2000,
+	"ptBR": 0x4000,
+	"itIT": 0x8000,
+	"ptPT": 0x10000,
+}
+
+// Content flags of the root blocks.
+const (
+	ContentFlagLowViolence = 0x80
+	ContentFlagNoNameHash  = 0x10000000
+)
+
+// unknownPrefix prefixes the FileDataID of the files without name.
+const unknownPrefix = "unknown/"
+
+// Block is the header of a group of files of the root.
+type Block struct {
+	Count        uint32
+	ContentFlags uint32
+	LocaleFlags  uint32
+}
+
+// Entry is a file of a root block.
+type Entry struct {
+	FileDataID  uint32
+	ContentHash [0x10]uint8
+	NameHash    uint64 // zero if the block has no name hashes
+}
+
+type Root struct {
+	contentHashes map[uint32][0x10]byte // FileDataID => content hash of the selected entry
+	locales       map[uint32]uint32     // FileDataID => locale flags of all entries
+	hashToID      map[uint64]uint32
+	idToName      map[uint32]string
+	nameToID      map[string]uint32
+}
+
+// Files returns the names of all files of the listfile, files without name are named
+// "unknown/<FileDataID>".
+func (r *Root) Files() ([]string, error) {
+	names := make([]string, 0, len(r.contentHashes))
+	for id := range r.contentHashes {
+		name, ok := r.idToName[id]
+		if !ok {
+			name = unknownPrefix + strconv.FormatUint(uint64(id), 10)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (r *Root) ContentHash(filename string) ([]byte, error) {
+	id, ok := r.fileDataID(filename)
+	if !ok {
+		return nil, errors.WithStack(fmt.Errorf("%s file name not found", filename))
+	}
+	return r.FileDataIDContentHash(id)
+}
+
+// FileDataIDContentHash returns the content hash of the file with the FileDataID id.
+func (r *Root) FileDataIDContentHash(id uint32) ([]byte, error) {
+	contentHash, ok := r.contentHashes[id]
+	if !ok {
+		return nil, errors.WithStack(fmt.Errorf("FileDataID %d not found", id))
+	}
+	return contentHash[:], nil
+}
+
+// FileDataIDs returns the FileDataIDs of all files sorted in ascending order.
+func (r *Root) FileDataIDs() []uint32 {
+	ids := make([]uint32, 0, len(r.contentHashes))
+	for id := range r.contentHashes {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Locales returns the locales the file with the given filename exists in.
+func (r *Root) Locales(filename string) ([]string, error) {
+	id, ok := r.fileDataID(filename)
+	if !ok {
+		return nil, errors.WithStack(fmt.Errorf("%s file name not found", filename))
+	}
+	var locales []string
+	for locale, flag := range LocaleFlags {
+		if r.locales[id]&flag != 0 {
+			locales = append(locales, locale)
+		}
+	}
+	sort.Strings(locales)
+	return locales, nil
+}
+
+// fileDataID returns the FileDataID of filename which is either "unknown/<FileDataID>",
+// a name of the listfile or a name whose hash is within the root.
+func (r *Root) fileDataID(filename string) (uint32, bool) {
+	if strings.HasPrefix(filename, unknownPrefix) {
+		id, err := strconv.ParseUint(filename[len(unknownPrefix):], 10, 32)
+		if err == nil {
+			_, ok := r.contentHashes[uint32(id)]
+			return uint32(id), ok
+		}
+	}
+	if id, ok := r.nameToID[filename]; ok {
+		return id, true
+	}
+	id, ok := r.hashToID[NameHash(filename)]
+	return id, ok
+}
+
+// NewRoot parses the root and names its files using listfile, which can be nil.
+// If locale is not empty, only the files of the blocks of locale are exposed.
+// Otherwise the files of all blocks are exposed and the enUS entry of a file
+// takes precedence. Entries that are not low violence take precedence.
+func NewRoot(root []byte, locale string, listfile map[uint32]string) (*Root, error) {
+	var localeFlag uint32
+	if locale != "" {
+		var ok bool
+		if localeFlag, ok = LocaleFlags[locale]; !ok {
+			return nil, errors.WithStack(fmt.Errorf("unknown locale %s", locale))
+		}
+	}
+	blocks, err := parseRoot(bytes.NewReader(root))
+	if err != nil {
+		return nil, err
+	}
+	r := &Root{
+		contentHashes: map[uint32][0x10]byte{},
+		locales:       map[uint32]uint32{},
+		hashToID:      map[uint64]uint32{},
+		idToName:      map[uint32]string{},
+		nameToID:      map[string]uint32{},
+	}
+	ranks := map[uint32]int{}
+	for _, block := range blocks {
+		if locale != "" && block.LocaleFlags&localeFlag == 0 {
+			for _, entry := range block.entries {
+				r.locales[entry.FileDataID] |= block.LocaleFlags
+			}
+			continue
+		}
+		rank := 0
+		if block.LocaleFlags&LocaleFlags["enUS"] != 0 {
+			rank += 2
+		}
+		if block.ContentFlags&ContentFlagLowViolence == 0 {
+			rank++
+		}
+		for _, entry := range block.entries {
+			r.locales[entry.FileDataID] |= block.LocaleFlags
+			if entry.NameHash != 0 {
+				r.hashToID[entry.NameHash] = entry.FileDataID
+			}
+			if prev, ok := ranks[entry.FileDataID]; ok && prev >= rank {
+				continue
+			}
+			ranks[entry.FileDataID] = rank
+			r.contentHashes[entry.FileDataID] = entry.ContentHash
+		}
+	}
+	for id, name := range listfile {
+		if _, ok := r.contentHashes[id]; !ok {
+			continue
+		}
+		name = common.CleanPath(name)
+		r.idToName[id] = name
+		r.nameToID[name] = id
+	}
+	return r, nil
+}
+
+// block is a parsed root block.
+type block struct {
+	Block
+	entries []Entry
+}
+
+func parseRoot(r *bytes.Reader) ([]block, error) {
+	var magic uint32
+	if err := binary.Read(r, binary.LittleEndian, &magic); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// version is 0 for legacy roots, MFST roots start with the "MFST" magic.
+	version := 0
+	if magic == 0x4D465354 {
+		var fields [2]uint32
+		if err := binary.Read(r, binary.LittleEndian, &fields); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		version = 1
+		// Since 10.1.7 the header starts with its size and a version,
+		// before it starts with the total and named file counts.
+		if fields[0] == 0x18 {
+			version = int(fields[1])
+			var counts [3]uint32 // total file count, named file count, padding
+			if err := binary.Read(r, binary.LittleEndian, &counts); err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+		if version < 1 || version > 2 {
+			return nil, errors.WithStack(fmt.Errorf("unsupported root version %d", version))
+		}
+	} else if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var blocks []block
+	for r.Len() > 0 {
+		b, err := parseBlock(r, version)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, nil
+}
+
+// parseBlock parses a block of the root.
+// Legacy blocks interleave content hashes and name hashes, MFST blocks store
+// all content hashes followed by all name hashes.
+func parseBlock(r *bytes.Reader, version int) (block, error) {
+	b := block{}
+	if err := binary.Read(r, binary.LittleEndian, &b.Count); err != nil {
+		return block{}, errors.WithStack(err)
+	}
+	if version == 2 {
+		var flags [3]uint32 // locale flags and two content flags
+		if err := binary.Read(r, binary.LittleEndian, &flags); err != nil {
+			return block{}, errors.WithStack(err)
+		}
+		var contentFlags uint8
+		if err := binary.Read(r, binary.LittleEndian, &contentFlags); err != nil {
+			return block{}, errors.WithStack(err)
+		}
+		b.LocaleFlags = flags[0]
+		b.ContentFlags = flags[1] | flags[2] | uint32(contentFlags)<<17
+	} else {
+		var flags [2]uint32 // content flags and locale flags
+		if err := binary.Read(r, binary.LittleEndian, &flags); err != nil {
+			return block{}, errors.WithStack(err)
+		}
+		b.ContentFlags, b.LocaleFlags = flags[0], flags[1]
+	}
+	hasNameHashes := version == 0 || b.ContentFlags&ContentFlagNoNameHash == 0
+	entrySize := 4 + 0x10
+	if hasNameHashes {
+		entrySize += 8
+	}
+	if uint64(b.Count)*uint64(entrySize) > uint64(r.Len()) {
+		return block{}, errors.WithStack(errors.New("invalid root block count"))
+	}
+
+	deltas := make([]int32, b.Count)
+	if err := binary.Read(r, binary.LittleEndian, &deltas); err != nil {
+		return block{}, errors.WithStack(err)
+	}
+	b.entries = make([]Entry, b.Count)
+	var id uint32
+	for i, delta := range deltas {
+		// FileDataIDs are stored as the difference with the previous FileDataID plus one.
+		if i == 0 {
+			id = uint32(delta)
+		} else {
+			id += 1 + uint32(delta)
+		}
+		b.entries[i].FileDataID = id
+	}
+	if version == 0 {
+		for i := range b.entries {
+			if _, err := io.ReadFull(r, b.entries[i].ContentHash[:]); err != nil {
+				return block{}, errors.WithStack(err)
+			}
+			if err := binary.Read(r, binary.LittleEndian, &b.entries[i].NameHash); err != nil {
+				return block{}, errors.WithStack(err)
+			}
+		}
+		return b, nil
+	}
+	for i := range b.entries {
+		if _, err := io.ReadFull(r, b.entries[i].ContentHash[:]); err != nil {
+			return block{}, errors.WithStack(err)
+		}
+	}
+	if hasNameHashes {
+		for i := range b.entries {
+			if err := binary.Read(r, binary.LittleEndian, &b.entries[i].NameHash); err != nil {
+				return block{}, errors.WithStack(err)
+			}
+		}
+	}
+	return b, nil
+}
+
+// ParseListfile parses a community listfile made of "FileDataID;filename" lines.
+func ParseListfile(r io.Reader) (map[uint32]string, error) {
+	listfile := map[uint32]string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		splits := strings.SplitN(line, ";", 2)
+		if len(splits) != 2 {
+			return nil, errors.WithStack(fmt.Errorf("invalid listfile line %q", line))
+		}
+		id, err := strconv.ParseUint(splits[0], 10, 32)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		listfile[uint32(id)] = splits[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return listfile, nil
+}
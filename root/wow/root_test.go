@@ -0,0 +1,161 @@
+package wow
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHashlittle2(t *testing.T) {
+	// Test vectors of lookup3.c.
+	tests := []struct {
+		key                  string
+		pc, pb               uint32
+		expectedC, expectedB uint32
+	}{
+		{"", 0, 0, 0xdeadbeef, 0xdeadbeef},
+		{"", 0, 0xdeadbeef, 0xbd5b7dde, 0xdeadbeef},
+		{"Four score and seven years ago", 0, 0, 0x17770551, 0xce7226e6},
+		{"Four score and seven years ago", 1, 0, 0xcd628161, 0x6cbea4b3},
+	}
+	for _, test := range tests {
+		c, b := hashlittle2([]byte(test.key), test.pc, test.pb)
+		if c != test.expectedC || b != test.expectedB {
+			t.Errorf("%q: expected %08x %08x got %08x %08x", test.key, test.expectedC, test.expectedB, c, b)
+		}
+	}
+	if NameHash("interface/icons/foo.blp") != NameHash("INTERFACE\\ICONS\\FOO.BLP") {
+		t.Error("name hash must ignore case and separators")
+	}
+}
+
+// testEntry is a file of a test root block.
+type testEntry struct {
+	id   uint32
+	name string
+}
+
+// testBlock writes a root block of version. The content hash of a file is the MD5 of its name.
+func testBlock(buf *bytes.Buffer, version int, contentFlags, localeFlags uint32, entries []testEntry) {
+	binary.Write(buf, binary.LittleEndian, uint32(len(entries)))
+	if version == 2 {
+		binary.Write(buf, binary.LittleEndian, []uint32{localeFlags, contentFlags, 0})
+		buf.WriteByte(0)
+	} else {
+		binary.Write(buf, binary.LittleEndian, []uint32{contentFlags, localeFlags})
+	}
+	prev := uint32(0)
+	for i, entry := range entries {
+		delta := entry.id
+		if i > 0 {
+			delta = entry.id - prev - 1
+		}
+		binary.Write(buf, binary.LittleEndian, delta)
+		prev = entry.id
+	}
+	for _, entry := range entries {
+		hash := md5.Sum([]byte(entry.name))
+		buf.Write(hash[:])
+		if version == 0 {
+			binary.Write(buf, binary.LittleEndian, NameHash(entry.name))
+		}
+	}
+	if version != 0 && contentFlags&ContentFlagNoNameHash == 0 {
+		for _, entry := range entries {
+			binary.Write(buf, binary.LittleEndian, NameHash(entry.name))
+		}
+	}
+}
+
+func TestNewRoot(t *testing.T) {
+	for version := 0; version <= 2; version++ {
+		buf := bytes.NewBuffer(nil)
+		switch version {
+		case 1:
+			buf.WriteString("TSFM")
+			binary.Write(buf, binary.LittleEndian, []uint32{5, 3})
+		case 2:
+			buf.WriteString("TSFM")
+			binary.Write(buf, binary.LittleEndian, []uint32{0x18, 2, 5, 3, 0})
+		}
+		all := LocaleFlags["enUS"] | LocaleFlags["frFR"]
+		testBlock(buf, version, 0, all, []testEntry{{10, "world/a.m2"}, {12, "world/b.m2"}})
+		testBlock(buf, version, 0, LocaleFlags["frFR"], []testEntry{{20, "sound/fr.ogg"}})
+		testBlock(buf, version, 0, LocaleFlags["enUS"], []testEntry{{20, "sound/en.ogg"}})
+		noNameHash := uint32(ContentFlagNoNameHash)
+		if version == 0 {
+			noNameHash = 0
+		}
+		testBlock(buf, version, noNameHash, all, []testEntry{{30, "unnamed.blp"}})
+		listfile := map[uint32]string{12: "world/b.m2", 20: "sound/voice.ogg", 99: "missing.blp"}
+
+		r, err := NewRoot(buf.Bytes(), "", listfile)
+		if err != nil {
+			t.Fatalf("version %d: %+v", version, err)
+		}
+		files, err := r.Files()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{"sound/voice.ogg", "unknown/10", "unknown/30", "world/b.m2"}
+		if !reflect.DeepEqual(files, expected) {
+			t.Fatalf("version %d: expected %v got %v", version, expected, files)
+		}
+		for filename, content := range map[string]string{
+			"sound/voice.ogg": "sound/en.ogg", // enUS takes precedence
+			"world/a.m2":      "world/a.m2",   // named by its name hash
+			"WORLD\\A.M2":     "world/a.m2",
+			"unknown/10":      "world/a.m2",
+			"unknown/30":      "unnamed.blp",
+		} {
+			hash, err := r.ContentHash(filename)
+			if err != nil {
+				t.Fatalf("version %d: %s: %+v", version, filename, err)
+			}
+			if expectedHash := md5.Sum([]byte(content)); !bytes.Equal(hash, expectedHash[:]) {
+				t.Fatalf("version %d: %s: unexpected content hash", version, filename)
+			}
+		}
+		if _, err := r.ContentHash("unnamed.blp"); (err == nil) != (version == 0) {
+			t.Fatalf("version %d: unexpected name hash lookup error %v", version, err)
+		}
+		if locales, err := r.Locales("sound/voice.ogg"); err != nil || !reflect.DeepEqual(locales, []string{"enUS", "frFR"}) {
+			t.Fatalf("version %d: unexpected locales %v %+v", version, locales, err)
+		}
+
+		r, err = NewRoot(buf.Bytes(), "frFR", listfile)
+		if err != nil {
+			t.Fatalf("version %d: %+v", version, err)
+		}
+		hash, err := r.FileDataIDContentHash(20)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if expectedHash := md5.Sum([]byte("sound/fr.ogg")); !bytes.Equal(hash, expectedHash[:]) {
+			t.Fatalf("version %d: expected the frFR file", version)
+		}
+		if ids := r.FileDataIDs(); !reflect.DeepEqual(ids, []uint32{10, 12, 20, 30}) {
+			t.Fatalf("version %d: unexpected FileDataIDs %v", version, ids)
+		}
+	}
+	if _, err := NewRoot(nil, "xxXX", nil); err == nil {
+		t.Fatal("expected unknown locale error")
+	}
+}
+
+func TestParseListfile(t *testing.T) {
+	listfile, err := ParseListfile(strings.NewReader("1;interface/a.blp\n\n2;sound/b;c.ogg\n"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expected := map[uint32]string{1: "interface/a.blp", 2: "sound/b;c.ogg"}
+	if !reflect.DeepEqual(listfile, expected) {
+		t.Fatalf("expected %v got %v", expected, listfile)
+	}
+	if _, err := ParseListfile(strings.NewReader("invalid\n")); err == nil {
+		t.Fatal("expected invalid line error")
+	}
+}
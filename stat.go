@@ -0,0 +1,142 @@
+package casc
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/jybp/casc/common"
+	"github.com/pkg/errors"
+)
+
+// FileInfo describes a file without extracting it.
+type FileInfo struct {
+	Name        string
+	ContentHash []byte
+	// Size is the decoded size of the file.
+	Size uint64
+	// EncodedKeys are the encoded keys (EKeys) of the content. The first one is the one extracted.
+	EncodedKeys [][]byte
+	// EncodedSize is the size of the BLTE data of the first encoded key, zero if unknown.
+	EncodedSize uint64
+	// ESpec is the encoding specification of the first encoded key, empty if unknown.
+	ESpec string
+	// Archive is the file storing the BLTE data of the first encoded key:
+	// "data.XXX" for local installations, the hex encoded archive hash for the CDN,
+	// or empty if the data is a loose file of the CDN or is not installed locally.
+	Archive string
+	// ArchiveOffset is the offset of the BLTE data within Archive.
+	ArchiveOffset int64
+	// Tags are the tags of the file within the install and download manifests
+	// (i.e. "Windows" or "enUS"). Files not listed by these manifests have no tags.
+	Tags []string
+}
+
+// Stat describes the file with the given filename using the encoding file, the indices
+// and the install and download manifests. The file content is never read.
+// The manifests are loaded by the first call to Stat. Tags is empty if they cannot be loaded.
+// Returns the error of the root if filename is unknown.
+func (e Explorer) Stat(filename string) (FileInfo, error) {
+	contentHash, err := e.root.ContentHash(filename)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	info, err := e.storage.Stat(contentHash)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	info.Name = filename
+	if err := e.tags.load(e); err == nil {
+		info.Tags = e.tags.of(info.ContentHash, info.EncodedKeys)
+	}
+	return info, nil
+}
+
+// encodingFileInfo returns the FileInfo of contentHash filled from the encoding file.
+// Returns casc.ErrNotFound if contentHash is not within the encoding file.
+func encodingFileInfo(encoding *common.Encoding, contentHash []byte) (FileInfo, error) {
+	entry, ok := encoding.Content(contentHash)
+	if !ok || len(entry.Ekey) == 0 {
+		return FileInfo{}, ErrNotFound
+	}
+	info := FileInfo{ContentHash: entry.Ckey, Size: entry.FileSize, EncodedKeys: entry.Ekey}
+	if eEntry, ok := encoding.Encoded(entry.Ekey[0]); ok {
+		info.EncodedSize = eEntry.EncodedSize
+	}
+	info.ESpec, _ = encoding.ESpec(entry.Ekey[0])
+	return info, nil
+}
+
+// tagIndex relates content hashes and encoded keys to the tags of the install and download manifests.
+// It is loaded once and shared by the copies of an Explorer.
+type tagIndex struct {
+	once      sync.Once
+	err       error
+	byContent map[string][]string
+	byEncoded map[string][]string
+}
+
+// load loads the manifests of e. Missing manifests are ignored.
+// The manifests are only loaded by the first call, whose error is returned by all calls.
+func (t *tagIndex) load(e Explorer) error {
+	t.once.Do(func() {
+		defer func() {
+			if t.err != nil {
+				fmt.Fprintf(common.Wlog, "tags not available: %+v\n", t.err)
+			}
+		}()
+		byContent := map[string][]string{}
+		byEncoded := map[string][]string{}
+		if len(e.storage.InstallHash()) > 0 {
+			install, err := e.Install()
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				t.err = err
+				return
+			}
+			if install != nil {
+				for _, entry := range install.Entries {
+					byContent[string(entry.ContentHash)] = appendTags(byContent[string(entry.ContentHash)], entry.Tags)
+				}
+			}
+		}
+		if len(e.storage.DownloadHash()) > 0 {
+			download, err := e.Download()
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				t.err = err
+				return
+			}
+			if download != nil {
+				for _, entry := range download.Entries {
+					byEncoded[string(entry.EncodedHash)] = appendTags(byEncoded[string(entry.EncodedHash)], entry.Tags)
+				}
+			}
+		}
+		t.byContent, t.byEncoded = byContent, byEncoded
+	})
+	return t.err
+}
+
+// of returns the tags of the content contentHash encoded as encodedKeys.
+func (t *tagIndex) of(contentHash []byte, encodedKeys [][]byte) []string {
+	tags := appendTags(nil, t.byContent[string(contentHash)])
+	for _, ekey := range encodedKeys {
+		tags = appendTags(tags, t.byEncoded[string(ekey)])
+	}
+	return tags
+}
+
+// appendTags appends the tags not already within tags.
+func appendTags(tags, add []string) []string {
+	for _, tag := range add {
+		found := false
+		for _, t := range tags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,108 @@
+// Package zbsdiff implements applying ZBSDIFF1 patches.
+//
+// ZBSDIFF1 is the bsdiff format used by TACT patches where the control, diff and
+// extra blocks are zlib compressed and integers are stored as big endian int64.
+package zbsdiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+const signature = "ZBSDIFF1"
+
+type header struct {
+	Signature   [8]byte
+	ControlSize int64
+	DiffSize    int64
+	NewSize     int64
+}
+
+// Apply returns the result of applying patch to old.
+func Apply(old, patch []byte) ([]byte, error) {
+	h := header{}
+	r := bytes.NewReader(patch)
+	if err := binary.Read(r, binary.BigEndian, &h); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if string(h.Signature[:]) != signature {
+		return nil, errors.WithStack(errors.New("invalid zbsdiff signature"))
+	}
+	rest := patch[len(patch)-r.Len():]
+	if h.ControlSize < 0 || h.DiffSize < 0 || h.NewSize < 0 || h.ControlSize+h.DiffSize > int64(len(rest)) {
+		return nil, errors.WithStack(errors.New("invalid zbsdiff header"))
+	}
+	control, err := inflate(rest[:h.ControlSize])
+	if err != nil {
+		return nil, err
+	}
+	diff, err := inflate(rest[h.ControlSize : h.ControlSize+h.DiffSize])
+	if err != nil {
+		return nil, err
+	}
+	extra, err := inflate(rest[h.ControlSize+h.DiffSize:])
+	if err != nil {
+		return nil, err
+	}
+
+	errCorrupted := errors.New("corrupted zbsdiff patch")
+	// The new data is made of the diff and extra blocks.
+	if h.NewSize > int64(diff.Len()+extra.Len()) {
+		return nil, errors.WithStack(errCorrupted)
+	}
+	newB := make([]byte, 0, h.NewSize)
+	var oldPos int64
+	for int64(len(newB)) < h.NewSize {
+		var ctrl [3]int64
+		if err := binary.Read(control, binary.BigEndian, &ctrl); err != nil {
+			return nil, errors.WithStack(errCorrupted)
+		}
+		diffLen, extraLen, seek := ctrl[0], ctrl[1], ctrl[2]
+		if diffLen < 0 || extraLen < 0 || int64(len(newB))+diffLen+extraLen > h.NewSize ||
+			diffLen > int64(diff.Len()) || extraLen > int64(extra.Len()) {
+			return nil, errors.WithStack(errCorrupted)
+		}
+
+		// Add the diff block to the old data.
+		start := len(newB)
+		newB = newB[:start+int(diffLen)]
+		if _, err := io.ReadFull(diff, newB[start:]); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for i := int64(0); i < diffLen; i++ {
+			if pos := oldPos + i; pos >= 0 && pos < int64(len(old)) {
+				newB[int64(start)+i] += old[pos]
+			}
+		}
+		oldPos += diffLen
+
+		// Copy the extra block.
+		start = len(newB)
+		newB = newB[:start+int(extraLen)]
+		if _, err := io.ReadFull(extra, newB[start:]); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		oldPos += seek
+	}
+	return newB, nil
+}
+
+func inflate(b []byte) (*bytes.Reader, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	inflated, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := zr.Close(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return bytes.NewReader(inflated), nil
+}
@@ -0,0 +1,82 @@
+package zbsdiff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"testing"
+)
+
+func deflate(b []byte) []byte {
+	buf := bytes.NewBuffer(nil)
+	w := zlib.NewWriter(buf)
+	w.Write(b)
+	w.Close()
+	return buf.Bytes()
+}
+
+// patch creates a ZBSDIFF1 patch from control triples, diff and extra blocks.
+func patch(control [][3]int64, diff, extra []byte, newSize int64) []byte {
+	ctrl := bytes.NewBuffer(nil)
+	for _, c := range control {
+		binary.Write(ctrl, binary.BigEndian, c)
+	}
+	ctrlZ, diffZ, extraZ := deflate(ctrl.Bytes()), deflate(diff), deflate(extra)
+	b := bytes.NewBufferString("ZBSDIFF1")
+	binary.Write(b, binary.BigEndian, []int64{int64(len(ctrlZ)), int64(len(diffZ)), newSize})
+	b.Write(ctrlZ)
+	b.Write(diffZ)
+	b.Write(extraZ)
+	return b.Bytes()
+}
+
+func TestApply(t *testing.T) {
+	old := []byte("hello, world")
+	tests := []struct {
+		name     string
+		patch    []byte
+		expected string
+	}{
+		{
+			name:     "identity",
+			patch:    patch([][3]int64{{12, 0, 0}}, make([]byte, 12), nil, 12),
+			expected: "hello, world",
+		},
+		{
+			name: "diff and extra",
+			// "hello" is modified to "jello", ", world" is skipped and "!" is appended.
+			patch:    patch([][3]int64{{5, 1, 7}}, []byte{2, 0, 0, 0, 0}, []byte("!"), 6),
+			expected: "jello!",
+		},
+		{
+			name: "seek backward",
+			// "world" is copied then the old data is read again from "hello".
+			patch:    patch([][3]int64{{0, 0, 7}, {5, 1, -12}, {5, 0, 0}}, make([]byte, 10), []byte(" "), 11),
+			expected: "world hello",
+		},
+	}
+	for _, test := range tests {
+		actual, err := Apply(old, test.patch)
+		if err != nil {
+			t.Fatalf("%s: %+v", test.name, err)
+		}
+		if string(actual) != test.expected {
+			t.Fatalf("%s: expected %q got %q", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestApplyCorrupted(t *testing.T) {
+	old := []byte("hello, world")
+	for name, p := range map[string][]byte{
+		"signature":     append([]byte("BSDIFF40"), patch([][3]int64{{12, 0, 0}}, make([]byte, 12), nil, 12)[8:]...),
+		"missing diff":  patch([][3]int64{{12, 0, 0}}, make([]byte, 4), nil, 12),
+		"missing ctrl":  patch(nil, make([]byte, 12), nil, 12),
+		"too large":     patch([][3]int64{{12, 0, 0}}, make([]byte, 12), nil, 1<<40),
+		"negative size": patch([][3]int64{{-1, 0, 0}}, make([]byte, 12), nil, 12),
+	} {
+		if _, err := Apply(old, p); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}